@@ -1,8 +1,11 @@
 package gomini
 
 import (
+	"fmt"
+	"net"
 	"github.com/spf13/afero"
 	"os"
+	"time"
 )
 
 type BundleStatus int
@@ -40,11 +43,99 @@ func (b BundleStatus) String() string {
 	panic("illegal bundle status")
 }
 
+// BundleStatusEvent describes a single status transition of a bundle.
+// Cause is set whenever the transition was caused by an error. Bundle
+// is nil for download transitions, which happen before the downloaded
+// bundle version is installed.
+type BundleStatusEvent struct {
+	Bundle    Bundle
+	BundleID  string
+	OldStatus BundleStatus
+	NewStatus BundleStatus
+	Cause     error
+}
+
+type BundleStatusListener func(event BundleStatusEvent)
+
+// BundleFailure describes why a bundle moved to BundleStatusFailed.
+// StackTrace contains the script stack trace if the failure was caused
+// by a script exception, ImportChain lists the modules which were being
+// loaded at the time of the failure, starting with the entrypoint.
+type BundleFailure struct {
+	Cause       error
+	StackTrace  string
+	ImportChain []string
+	Time        time.Time
+}
+
+func (f *BundleFailure) Error() string {
+	return f.Cause.Error()
+}
+
+// BundleLimits restricts the script execution of a bundle, zero values
+// don't restrict. ExecutionTimeout is the maximum wall-clock time of a
+// single job (loading the entrypoint, a timer or event callback),
+// CpuShare is the maximum share (0 < share <= 1) of wall-clock time the
+// bundle may spend executing scripts, measured over 10 second windows.
+// MaxMemory is the maximum of the estimated memory usage in bytes, which
+// is measured after jobs and on native calls, see Bundle.MemoryUsage. IOBandwidth is the
+// maximum number of bytes per second the bundle may read and write
+// through its filesystem and throttled network connections. DataQuota
+// is the maximum total size in bytes of the files in the bundle's
+// writable data section.
+type BundleLimits struct {
+	ExecutionTimeout time.Duration
+	CpuShare         float64
+	MaxMemory        uint64
+	IOBandwidth      uint64
+	DataQuota        uint64
+}
+
+// ExecutionLimitError is thrown into a script execution which exceeded
+// the BundleLimits of its bundle and is the error of the execution, if it
+// was interrupted after ignoring the exception. Limit is either "timeout"
+// or "cpu_share", Allowed is the execution time the job was granted.
+type ExecutionLimitError struct {
+	BundleID string
+	Limit    string
+	Allowed  time.Duration
+}
+
+func (e *ExecutionLimitError) Error() string {
+	return fmt.Sprintf("execution of bundle %s exceeded %s (%s limit)", e.BundleID, e.Allowed, e.Limit)
+}
+
+// MemoryLimitError is the failure cause of a bundle whose estimated
+// memory usage exceeded the MaxMemory of its BundleLimits.
+type MemoryLimitError struct {
+	BundleID string
+	Usage    uint64
+	Limit    uint64
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("memory usage of bundle %s exceeds its limit: %d bytes (limit: %d bytes)", e.BundleID, e.Usage, e.Limit)
+}
+
+// BundleHealthCheck is called repeatedly after an updated bundle was
+// started, until it returns nil or the health check timeout elapsed.
+type BundleHealthCheck func(bundle Bundle) error
+
+// BundleUpdateOptions configures how an updated bundle is verified.
+// Without a HealthCheck the update succeeds as soon as the new
+// entrypoint was executed. A zero HealthCheckTimeout defaults to 30s.
+type BundleUpdateOptions struct {
+	HealthCheck        BundleHealthCheck
+	HealthCheckTimeout time.Duration
+}
+
 type BundleFilesystemConfig struct {
 	kernelFilesystem    afero.Fs
 	appPath             string
 	appInfo             os.FileInfo
 	writableSection     bool
+	dataPath            string
+	dataQuota           uint64
 	keyManager          KeyManager
 	NewModuleFilesystem func() (afero.Fs, error)
 }
@@ -52,11 +143,23 @@ type BundleFilesystemConfig struct {
 type Bundle interface {
 	ID() string
 	Name() string
+	Version() Version
 	Privileged() bool
 	Privileges() []string
 	SecurityInterceptor() SecurityInterceptor
 	Export(value Value, target interface{}) error
 	Status() BundleStatus
+	Failure() *BundleFailure
+
+	// MemoryUsage returns the estimated memory usage of the bundle's
+	// sandbox in bytes, which is measured periodically after script
+	// executions and on native calls of bundles with a memory limit.
+	// Stopped bundles and bundles without a memory limit report 0.
+	MemoryUsage() uint64
+
+	// ThrottleConn wraps a network connection opened on behalf of the
+	// bundle, so the connection shares the bundle's I/O bandwidth.
+	ThrottleConn(conn net.Conn) (net.Conn, error)
 	Filesystem() afero.Fs
 
 	Null() Value
@@ -80,5 +183,10 @@ type Bundle interface {
 	popLoaderStack() string
 	pushLoaderStack(element string)
 	getBasePath() string
+	getEventLoop() *eventLoop
+	getKernel() *kernel
 	setBundleStatus(status BundleStatus)
+	setBundleStatusWithCause(status BundleStatus, cause error)
+	crash(err error)
+	isPermitted(property string) bool
 }
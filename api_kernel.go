@@ -1,26 +1,66 @@
 package gomini
 
 import (
+	"io"
+	"time"
 	"github.com/spf13/afero"
 )
 
 const (
-	KernelVfsAppsPath     = "/kernel/apps"
-	KernelVfsCachePath    = "/kernel/cache"
-	KernelVfsTypesPath    = "/kernel/@types"
-	KernelVfsWritablePath = "/kernel/data"
+	KernelVfsAppsPath      = "/kernel/apps"
+	KernelVfsCachePath     = "/kernel/cache"
+	KernelVfsTypesPath     = "/kernel/@types"
+	KernelVfsWritablePath  = "/kernel/data"
+	KernelVfsDownloadsPath = "/kernel/downloads"
 )
 
 type KeyManager interface {
 	GetKey(fingerprint string) ([]byte, error)
 }
 
+// SignaturePolicy decides which bundles the kernel accepts. Signatures
+// of .bacc archives are always verified using the KeyManager, archives
+// which are unsigned, tampered or signed by an unknown signer are
+// rejected. Directory bundles (including bundles installed from .tar.gz
+// repository archives) carry no signature and are accepted unless
+// RequireSigned is set.
+type SignaturePolicy struct {
+	RequireSigned bool
+}
+
 type KernelConfig struct {
 	NewKernelFilesystem func(baseFilesystem afero.Fs) (afero.Fs, error)
 	NewBundleFilesystem func(bundleFilesystemConfig BundleFilesystemConfig) (afero.Fs, error)
 	NewSandbox          func(bundle Bundle) Sandbox
 	KernelModules       []KernelModule
 	BundleApiProviders  []ApiProviderBinder
+	Repository          Repository
+	KeyManager          KeyManager
+	SignaturePolicy     SignaturePolicy
+
+	// TranspilerTarget is the TypeScript target (e.g. "es5", "es2017")
+	// used for the kernel and all bundles which don't define their own
+	// target. Defaults to the newest target supported by the sandbox.
+	TranspilerTarget string
+
+	// PolicyProvider decides on all accesses of bundles to modules of
+	// other bundles and the kernel. Defaults to NewDefaultPolicyProvider.
+	PolicyProvider PolicyProvider
+
+	// AuditLog receives the security audit records as JSON lines, see
+	// AuditRecord. NewRotatingAuditLog creates a file based sink with
+	// rotation. Without a sink no audit records are written.
+	AuditLog io.Writer
+
+	// BundleLimits caps the execution limits declared in the bundle.json
+	// files, bundles without declared limits are restricted to these.
+	BundleLimits BundleLimits
+
+	// LifecycleTimeout limits the execution of the entrypoints and onStop
+	// hooks of bundles, as no other bundle can be started or stopped while
+	// the kernel waits for them. Bundles with a shorter execution timeout
+	// keep theirs. Defaults to 10 seconds.
+	LifecycleTimeout time.Duration
 }
 
 type KernelModule interface {
@@ -45,4 +85,63 @@ type Kernel interface {
 	// Stop stops the kernel. No further scripts will be executed
 	// after this point.
 	Stop() error
+
+	// Bundles returns all currently installed app bundles,
+	// independent of their current BundleStatus.
+	Bundles() []Bundle
+
+	// Bundle returns the installed app bundle with the given id
+	// or nil if no such bundle is installed.
+	Bundle(id string) Bundle
+
+	// InstallBundle installs the bundle found at the given path,
+	// which is relative to the kernel virtual filesystem base. The
+	// bundle is not started automatically. Bundles with an invalid
+	// configuration stay registered in BundleStatusFailed state.
+	InstallBundle(path string) (Bundle, error)
+
+	// UninstallBundle stops the bundle with the given id, removes
+	// it from the kernel and deletes its files.
+	UninstallBundle(id string) error
+
+	// StartBundle starts the installed bundle with the given id by
+	// executing its entrypoint.
+	StartBundle(id string) error
+
+	// StopBundle stops the bundle with the given id and releases
+	// its sandbox.
+	StopBundle(id string) error
+
+	// RestartBundle stops and starts the bundle with the given id.
+	RestartBundle(id string) error
+
+	// AddBundleStatusListener registers a listener which is notified
+	// about every status transition of any bundle, including the kernel
+	// itself. Listeners are called synchronously, in order of the
+	// transitions, and must not call back into the bundle management
+	// functions. The returned function removes the listener again.
+	AddBundleStatusListener(listener BundleStatusListener) (remove func())
+
+	// UpdateBundle replaces the bundle with the given id by the new
+	// version found at the given path, which must not be located inside
+	// of KernelVfsAppsPath. The new version is staged next to the
+	// installed one and, if the bundle is running, started in its place.
+	// If the new version fails to start or doesn't pass the health check
+	// in time, the previous version is restored and started again.
+	UpdateBundle(id, path string, options BundleUpdateOptions) error
+
+	// RepositoryBundles returns all bundles available from the
+	// configured Repository.
+	RepositoryBundles() ([]RepositoryBundle, error)
+
+	// InstallFromRepository downloads and installs the newest version
+	// of the bundle with the given id matching the version range, e.g.
+	// "^1.2". An empty version range matches any version. Downloads
+	// are reported as BundleStatusDownloading status transitions.
+	InstallFromRepository(id, version string) (Bundle, error)
+
+	// UpdateFromRepository updates the bundle with the given id to the
+	// newest version matching the version range, see UpdateBundle. The
+	// bundle is left untouched if no newer version is available.
+	UpdateFromRepository(id, version string, options BundleUpdateOptions) error
 }
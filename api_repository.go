@@ -0,0 +1,39 @@
+package gomini
+
+import (
+	"io"
+	"net/http"
+)
+
+// RepositoryBundle is a single bundle archive listed in a repository
+// index. Checksum is the SHA-256 of the archive ("sha256:<hex>"), URL
+// is either absolute or relative to the index and must point to a
+// ".bacc" file or a ".tar.gz" / ".tgz" archive with the bundle.json
+// at its root.
+type RepositoryBundle struct {
+	ID       string `json:"id"`
+	Version  string `json:"version"`
+	Checksum string `json:"checksum"`
+	URL      string `json:"url"`
+}
+
+// Repository provides bundle archives to install or update bundles from.
+// The BundleManager verifies the checksum of every opened archive.
+type Repository interface {
+	// Bundles returns all bundles listed in the repository index,
+	// after the index signature was verified.
+	Bundles() ([]RepositoryBundle, error)
+
+	// Open opens the archive of the given repository bundle.
+	Open(bundle RepositoryBundle) (io.ReadCloser, error)
+}
+
+// RepositoryConfig configures the index based repository created by
+// NewRepository. IndexURL is a file:// or http(s):// URL of the index,
+// the signature is expected next to it with an additional ".sig" suffix.
+// If HttpClient is nil, http.DefaultClient is used.
+type RepositoryConfig struct {
+	IndexURL   string
+	KeyManager KeyManager
+	HttpClient *http.Client
+}
@@ -17,14 +17,60 @@ type Sandbox interface {
 	NewTypeError(args ...interface{}) Object
 	NewError(err error) Object
 
+	// NewPromise creates a native Promise and its resolving functions.
+	// Like any other script execution, resolve and reject must only be
+	// called on the bundle's event loop. Reactions of the promise run
+	// before the resolving function returns.
+	NewPromise() (promise Object, resolve func(value interface{}) error, reject func(reason interface{}) error)
+
 	NewModuleProxy(object Object, objectName string, caller Bundle) (Object, error)
+
+	// RevokeProxies revokes all proxies between the sandbox and other
+	// sandboxes, in both directions. Later accesses through them throw an
+	// error telling that the sandbox's bundle is no longer available.
+	RevokeProxies()
 	IsAccessible(module Module, caller Bundle) error
 
 	Compile(filename, source string) (script Script, cacheable bool, err error)
 	Execute(script Script) (Value, error)
 	CaptureCallStack(maxStackFrames int) []StackFrame
+
+	// ErrorStackTrace returns the script stack trace of the given error
+	// or an empty string if the error wasn't caused by a script exception.
+	ErrorStackTrace(err error) string
 	NewDebugger() (interface{}, error)
 
+	// Interrupt aborts the currently running script execution, which
+	// returns an error wrapping the reason. It is safe to be called from
+	// any goroutine. If no script is running, the next execution is
+	// aborted, unless ClearInterrupt is called before.
+	Interrupt(reason error)
+	ClearInterrupt()
+
+	// Throw raises the reason as an exception, which scripts can catch,
+	// as soon as the running script calls a native function. It is safe
+	// to be called from any goroutine, ClearInterrupt discards the reason
+	// if it wasn't thrown yet.
+	Throw(reason error)
+
+	// SetNativeCallHook sets a hook, which is called on the event loop
+	// whenever the running script calls a native function. These calls
+	// are the only points during a script execution at which the sandbox
+	// can be inspected, e.g. by MemoryUsage.
+	SetNativeCallHook(hook func())
+
+	// MemoryUsage estimates the memory in bytes used by all values
+	// reachable from the global object and the given roots, including
+	// Go values handed to scripts. Variables only captured by closures
+	// are invisible to the script engine's API and not accounted. Like
+	// any other script execution, it must only be called on the bundle's
+	// event loop.
+	MemoryUsage(roots ...Object) (uint64, error)
+
+	// ScriptTarget returns the newest TypeScript target (e.g. "es2017")
+	// the script engine supports natively.
+	ScriptTarget() string
+
 	Global() Object
 	NullValue() Value
 	UndefinedValue() Value
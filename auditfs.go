@@ -0,0 +1,105 @@
+package gomini
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"github.com/spf13/afero"
+)
+
+// auditFs records the filesystem operations of a privileged bundle to
+// the audit log. All operations on the data section are recorded, as
+// well as all attempts to modify any other part of the filesystem.
+type auditFs struct {
+	afero.Fs
+	bundle   Bundle
+	auditLog *auditLog
+}
+
+func newAuditFs(base afero.Fs, bundle Bundle, auditLog *auditLog) afero.Fs {
+	return &auditFs{
+		Fs:       base,
+		bundle:   bundle,
+		auditLog: auditLog,
+	}
+}
+
+func (a *auditFs) Name() string {
+	return "AuditFs"
+}
+
+func (a *auditFs) Create(name string) (afero.File, error) {
+	file, err := a.Fs.Create(name)
+	a.__record("create", name, err)
+	return file, err
+}
+
+func (a *auditFs) Open(name string) (afero.File, error) {
+	file, err := a.Fs.Open(name)
+	if inDataSection(name) {
+		a.__record("open", name, err)
+	}
+	return file, err
+}
+
+func (a *auditFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := a.Fs.OpenFile(name, flag, perm)
+	if writable := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0; writable {
+		a.__record("write", name, err)
+	} else if inDataSection(name) {
+		a.__record("open", name, err)
+	}
+	return file, err
+}
+
+func (a *auditFs) Mkdir(name string, perm os.FileMode) error {
+	err := a.Fs.Mkdir(name, perm)
+	a.__record("mkdir", name, err)
+	return err
+}
+
+func (a *auditFs) MkdirAll(path string, perm os.FileMode) error {
+	err := a.Fs.MkdirAll(path, perm)
+	a.__record("mkdir", path, err)
+	return err
+}
+
+func (a *auditFs) Remove(name string) error {
+	err := a.Fs.Remove(name)
+	a.__record("remove", name, err)
+	return err
+}
+
+func (a *auditFs) RemoveAll(path string) error {
+	err := a.Fs.RemoveAll(path)
+	a.__record("remove", path, err)
+	return err
+}
+
+func (a *auditFs) Rename(oldname, newname string) error {
+	err := a.Fs.Rename(oldname, newname)
+	a.__record("rename", oldname+" -> "+newname, err)
+	return err
+}
+
+func (a *auditFs) Chmod(name string, mode os.FileMode) error {
+	err := a.Fs.Chmod(name, mode)
+	a.__record("chmod", name, err)
+	return err
+}
+
+func (a *auditFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	err := a.Fs.Chtimes(name, atime, mtime)
+	a.__record("chtimes", name, err)
+	return err
+}
+
+func (a *auditFs) __record(operation, path string, err error) {
+	a.auditLog.recordFilesystem(a.bundle, operation, path, err)
+}
+
+func inDataSection(path string) bool {
+	path = filepath.Clean("/" + path)
+	return path == bundleDataPath || strings.HasPrefix(path, bundleDataPath+"/")
+}
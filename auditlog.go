@@ -0,0 +1,243 @@
+package gomini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"github.com/apex/log"
+	"github.com/go-errors/errors"
+	"github.com/spf13/afero"
+)
+
+const (
+	AuditKindAccess     = "access"
+	AuditKindFilesystem = "filesystem"
+
+	AuditVerdictAllow = "allow"
+	AuditVerdictDeny  = "deny"
+
+	auditLocationFrames = 8
+)
+
+// AuditRecord is written as a single JSON line to the audit log. Access
+// records describe decisions on accesses to modules of other bundles,
+// filesystem records operations of bundles on their privileged data
+// section and write attempts to their filesystem.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	Verdict    string    `json:"verdict"`
+	Reason     string    `json:"reason,omitempty"`
+	CallerID   string    `json:"caller_id"`
+	CallerName string    `json:"caller_name"`
+	TargetID   string    `json:"target_id,omitempty"`
+	TargetName string    `json:"target_name,omitempty"`
+	Property   string    `json:"property,omitempty"`
+	Operation  string    `json:"operation,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Location   string    `json:"location,omitempty"`
+}
+
+type auditLog struct {
+	sink  io.Writer
+	mutex sync.Mutex
+}
+
+func newAuditLog(sink io.Writer) *auditLog {
+	if sink == nil {
+		return nil
+	}
+	return &auditLog{
+		sink: sink,
+	}
+}
+
+// record writes the record as a JSON line, a nil audit log discards
+// all records
+func (a *auditLog) record(record AuditRecord) {
+	if a == nil {
+		return
+	}
+	record.Time = time.Now().UTC()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("AuditLog: Failed to encode audit record: %s", err.Error())
+		return
+	}
+	line = append(line, '\n')
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, err := a.sink.Write(line); err != nil {
+		log.Errorf("AuditLog: Failed to write audit record: %s", err.Error())
+	}
+}
+
+func (a *auditLog) recordAccess(caller, target Bundle, property string, decision PolicyDecision) {
+	if a == nil {
+		return
+	}
+
+	verdict := AuditVerdictAllow
+	if !decision.Allowed {
+		verdict = AuditVerdictDeny
+	}
+	a.record(AuditRecord{
+		Kind:       AuditKindAccess,
+		Verdict:    verdict,
+		Reason:     decision.Reason,
+		CallerID:   caller.ID(),
+		CallerName: caller.Name(),
+		TargetID:   target.ID(),
+		TargetName: target.Name(),
+		Property:   property,
+		Location:   scriptLocation(caller),
+	})
+}
+
+func (a *auditLog) recordFilesystem(bundle Bundle, operation, path string, err error) {
+	if a == nil {
+		return
+	}
+
+	verdict, reason := AuditVerdictAllow, ""
+	if err != nil {
+		reason = err.Error()
+		if os.IsPermission(err) || isQuotaExceeded(err) {
+			verdict = AuditVerdictDeny
+		}
+	}
+	a.record(AuditRecord{
+		Kind:       AuditKindFilesystem,
+		Verdict:    verdict,
+		Reason:     reason,
+		CallerID:   bundle.ID(),
+		CallerName: bundle.Name(),
+		Operation:  operation,
+		Path:       path,
+	})
+}
+
+// scriptLocation returns the innermost script position of the bundle's
+// current call stack, it must only be called on the bundle's event loop
+func scriptLocation(bundle Bundle) string {
+	sandbox := bundle.Sandbox()
+	if sandbox == nil {
+		return ""
+	}
+	for _, frame := range sandbox.CaptureCallStack(auditLocationFrames) {
+		if frame.SrcName() == "" || strings.HasPrefix(frame.SrcName(), "<native>") {
+			continue
+		}
+		position := frame.Position()
+		return fmt.Sprintf("%s::%s[%d:%d]", frame.SrcName(), frame.FuncName(), position.Line, position.Col)
+	}
+	return ""
+}
+
+// rotatingFile is an audit log sink writing to a file, which is rotated
+// to filename.1 ... filename.<maxFiles> when it would exceed its
+// maximum size. Records are never split across files.
+type rotatingFile struct {
+	filesystem afero.Fs
+	filename   string
+	maxSize    int64
+	maxFiles   int
+	file       afero.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+// NewRotatingAuditLog creates an audit log sink for KernelConfig.AuditLog,
+// which appends to the given file and keeps at most maxFiles rotated
+// files of maxSize bytes.
+func NewRotatingAuditLog(filesystem afero.Fs, filename string, maxSize int64, maxFiles int) (io.WriteCloser, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("maximum audit log size must be positive")
+	}
+	if maxFiles < 1 {
+		return nil, errors.New("at least one rotated audit log file must be kept")
+	}
+
+	r := &rotatingFile{
+		filesystem: filesystem,
+		filename:   filename,
+		maxSize:    maxSize,
+		maxFiles:   maxFiles,
+	}
+	if err := r.__open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return 0, errors.New("audit log is closed")
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.__rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *rotatingFile) __open() error {
+	file, err := r.filesystem.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.New(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.New(err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) __rotate() error {
+	if err := r.file.Close(); err != nil {
+		return errors.New(err)
+	}
+	r.file = nil
+
+	// The oldest file is replaced by renaming the newer ones
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		source := fmt.Sprintf("%s.%d", r.filename, i)
+		if exists, _ := afero.Exists(r.filesystem, source); exists {
+			if err := r.filesystem.Rename(source, fmt.Sprintf("%s.%d", r.filename, i+1)); err != nil {
+				return errors.New(err)
+			}
+		}
+	}
+	if err := r.filesystem.Rename(r.filename, r.filename+".1"); err != nil {
+		return errors.New(err)
+	}
+	return r.__open()
+}
@@ -0,0 +1,141 @@
+package gomini_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"github.com/relationsone/gomini"
+	"github.com/spf13/afero"
+)
+
+// auditBuffer collects the audit records written by the kernel
+type auditBuffer struct {
+	t      *testing.T
+	buffer bytes.Buffer
+	mutex  sync.Mutex
+}
+
+func (b *auditBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *auditBuffer) records() []gomini.AuditRecord {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var records []gomini.AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(b.buffer.String()), "\n") {
+		var record gomini.AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			b.t.Fatalf("malformed audit record %q: %s", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func (b *auditBuffer) find(kind, verdict, property string) *gomini.AuditRecord {
+	for _, record := range b.records() {
+		if record.Kind == kind && record.Verdict == verdict && strings.HasPrefix(record.Property+record.Path, property) {
+			return &record
+		}
+	}
+	return nil
+}
+
+func TestAuditLogRecordsDecisions(t *testing.T) {
+	audit := &auditBuffer{t: t}
+	tk := newTestKernel(t, func(config *gomini.KernelConfig) {
+		config.AuditLog = audit
+		writeFileApi(config)
+	})
+	writeLibrary(tk)
+	tk.writeBundle("app", map[string]interface{}{
+		"requires":    []map[string]string{{"id": "lib"}},
+		"permissions": []string{"bundle:com.acme.lib/api.state"},
+		"privileges":  []string{"PRIVILEGE_DATA"},
+	}, map[string]string{
+		"index.ts": `
+			System.register(["bundle:com.acme.lib/api"], function (exports_1) {
+				var api;
+				return {
+					setters: [function (m) { api = m; }],
+					execute: function () {
+						api.state();
+						try {
+							api.increment(function () {});
+						} catch (e) {
+						}
+						report(writeFile("/data/state.txt", "state"));
+						report(writeFile("/index.ts", "overwritten"));
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+	tk.expectReports("written")
+	tk.nextReport()
+
+	allowed := audit.find(gomini.AuditKindAccess, gomini.AuditVerdictAllow, "bundle:com.acme.lib/api.state.apply")
+	if allowed == nil {
+		t.Fatal("allowed access wasn't recorded")
+	}
+	if allowed.CallerID != "app" || allowed.TargetName != "com.acme.lib" || allowed.Location == "" {
+		t.Fatalf("incomplete access record %+v", allowed)
+	}
+	denied := audit.find(gomini.AuditKindAccess, gomini.AuditVerdictDeny, "bundle:com.acme.lib/api.increment")
+	if denied == nil || denied.Reason == "" {
+		t.Fatalf("denied access wasn't recorded: %+v", denied)
+	}
+
+	if audit.find(gomini.AuditKindFilesystem, gomini.AuditVerdictAllow, "/data/state.txt") == nil {
+		t.Fatal("write into the data section wasn't recorded")
+	}
+	if audit.find(gomini.AuditKindFilesystem, gomini.AuditVerdictDeny, "/index.ts") == nil {
+		t.Fatal("write outside of the data section wasn't recorded")
+	}
+}
+
+func TestRotatingAuditLog(t *testing.T) {
+	filesystem := afero.NewMemMapFs()
+	sink, err := gomini.NewRotatingAuditLog(filesystem, "/audit.log", 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := sink.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are never split and the oldest file is dropped
+	expected := map[string]string{
+		"/audit.log":   "fourth\n",
+		"/audit.log.1": "third\n",
+		"/audit.log.2": "second\n",
+	}
+	for filename, content := range expected {
+		data, err := afero.ReadFile(filesystem, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s contains %q, expected %q", filename, data, content)
+		}
+	}
+	if _, err := filesystem.Stat("/audit.log.3"); err == nil {
+		t.Fatal("more rotated files than configured were kept")
+	}
+
+	if _, err := gomini.NewRotatingAuditLog(filesystem, "/audit.log", 0, 1); err == nil {
+		t.Fatal("audit log without a maximum size was created")
+	}
+}
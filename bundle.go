@@ -2,26 +2,41 @@ package gomini
 
 import (
 	"github.com/go-errors/errors"
+	"fmt"
+	"net"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"github.com/spf13/afero"
 	"github.com/apex/log"
 	"github.com/efarrer/iothrottler"
 )
 
 type bundle struct {
-	kernel      *kernel
-	id          string
-	name        string
-	basePath    string
-	filesystem  afero.Fs
-	status      BundleStatus
-	sandbox     Sandbox
-	privileges  []string
-	privileged  bool
-	modules     []*module
-	loaderStack []string
-	ioPool      *iothrottler.IOThrottlerPool
+	kernel        *kernel
+	id            string
+	name          string
+	version       Version
+	basePath      string
+	filesystem    afero.Fs
+	statusMutex   sync.Mutex
+	status        BundleStatus
+	sandboxMutex  sync.RWMutex
+	sandbox       Sandbox
+	eventLoop     *eventLoop
+	privileges    []string
+	privileged    bool
+	permissions   []permission
+	modules       []*module
+	loaderStack   []string
+	ioPool        *iothrottler.IOThrottlerPool
+	config        *bundleConfig
+	restartPolicy *restartPolicy
+	limits        BundleLimits
+	memoryUsage   uint64
+	failure       *BundleFailure
 }
 
 func newBundle(kernel *kernel, basePath string, filesystem afero.Fs, id, name string, privileges []string) (*bundle, error) {
@@ -33,21 +48,49 @@ func newBundle(kernel *kernel, basePath string, filesystem afero.Fs, id, name st
 		basePath:    basePath,
 		filesystem:  filesystem,
 		loaderStack: make([]string, 0),
-		// TODO Add IO throttling using bundle#ioPool
-		// ioPool: iothrottler.NewIOThrottlerPool(iothrottler.BytesPerSecond * 1000),
 	}
 
-	bundle.sandbox = kernel.kernelConfig.NewSandbox(bundle)
-
+	bundle.newSandbox()
 	bundle.setBundleStatus(BundleStatusInstalled)
 
-	builder := bundle.NewObjectBuilder("")
-	builder.DefineGoFunction("<module-init>", "register", bundle.__systemRegister)
-	builder.BuildInto("System", bundle.sandbox.Global())
-
 	return bundle, nil
 }
 
+// newSandbox creates the sandbox and event loop of the bundle. Both are
+// read by other bundles' event loops, e.g. through CallBundle, and are
+// only replaced while holding the sandbox mutex.
+func (b *bundle) newSandbox() {
+	sandbox := b.kernel.kernelConfig.NewSandbox(b)
+	eventLoop := newEventLoop(b)
+	sandbox.SetNativeCallHook(eventLoop.budget.checkMemoryInJob)
+
+	b.sandboxMutex.Lock()
+	b.sandbox = sandbox
+	b.eventLoop = eventLoop
+	b.sandboxMutex.Unlock()
+
+	builder := b.NewObjectBuilder("")
+	builder.DefineGoFunction("<module-init>", "register", b.__systemRegister)
+	builder.DefineFunction("import", "import", b.__systemImport)
+	builder.BuildInto("System", b.sandbox.Global())
+}
+
+func (b *bundle) releaseSandbox() {
+	// Stopping waits for a running job, which may still hand out proxies,
+	// afterwards other bundles must not reach into the sandbox anymore
+	b.eventLoop.stop()
+	b.sandbox.RevokeProxies()
+	b.kernel.statusListeners.removeOwner(b.id)
+	b.modules = nil
+	b.loaderStack = make([]string, 0)
+	atomic.StoreUint64(&b.memoryUsage, 0)
+
+	// The stopped event loop stays in place and rejects further calls
+	b.sandboxMutex.Lock()
+	b.sandbox = nil
+	b.sandboxMutex.Unlock()
+}
+
 func (b *bundle) init(kernel *kernel) error {
 	if err := kernel.bundleManager.registerDefaults(b); err != nil {
 		return err
@@ -68,10 +111,53 @@ func (b *bundle) Filesystem() afero.Fs {
 	return b.filesystem
 }
 
+// Status and Failure are read by other goroutines than the bundle
+// manager changing them
 func (b *bundle) Status() BundleStatus {
+	b.statusMutex.Lock()
+	defer b.statusMutex.Unlock()
 	return b.status
 }
 
+func (b *bundle) Failure() *BundleFailure {
+	b.statusMutex.Lock()
+	defer b.statusMutex.Unlock()
+	return b.failure
+}
+
+func (b *bundle) MemoryUsage() uint64 {
+	return atomic.LoadUint64(&b.memoryUsage)
+}
+
+func (b *bundle) ThrottleConn(conn net.Conn) (net.Conn, error) {
+	if b.ioPool == nil {
+		return conn, nil
+	}
+	return b.ioPool.AddConn(conn)
+}
+
+// throttleIO restricts all reads and writes through the bundle's
+// filesystem to the given bandwidth in bytes per second
+func (b *bundle) throttleIO(bandwidth uint64) {
+	b.ioPool = iothrottler.NewIOThrottlerPool(iothrottler.BytesPerSecond * iothrottler.Bandwidth(bandwidth))
+	b.filesystem = newThrottledFs(b.filesystem, b.ioPool, b)
+}
+
+// waitUnaccounted runs the wait without accounting its duration to the
+// execution limits of the bundle's running job
+func (b *bundle) waitUnaccounted(wait func() error) error {
+	return b.getEventLoop().budget.exclude(wait)
+}
+
+// releaseIoPool stops the I/O throttler of a bundle, which is removed
+// from the kernel
+func (b *bundle) releaseIoPool() {
+	if b.ioPool != nil {
+		b.ioPool.ReleasePool()
+		b.ioPool = nil
+	}
+}
+
 func (b *bundle) findModuleByModuleFile(file string) *module {
 	filename := filepath.Base(file)
 	path := filepath.Dir(file)
@@ -125,6 +211,10 @@ func (b *bundle) Name() string {
 	return b.name
 }
 
+func (b *bundle) Version() Version {
+	return b.version
+}
+
 func (b *bundle) Privileged() bool {
 	return b.privileged
 }
@@ -133,14 +223,56 @@ func (b *bundle) Privileges() []string {
 	return b.privileges
 }
 
+func (b *bundle) hasPrivilege(privilege string) bool {
+	for _, p := range b.privileges {
+		if p == privilege {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *bundle) SecurityInterceptor() SecurityInterceptor {
 	return func(caller Bundle, property string) (accessGranted bool) {
-		// TODO: Implement a real security check here! For now make it easy and get it running again
-		return true
+		// Objects handed in by the caller itself, like callbacks, are
+		// always accessible
+		if !strings.HasPrefix(property, bundleImportPrefix) {
+			return true
+		}
+		if !caller.isPermitted(property) {
+			return false
+		}
+		if !strings.HasSuffix(property, "."+propertyInject) {
+			return true
+		}
+
+		// Other bundles may only inject modules which are explicitly exported
+		if b.config == nil {
+			return false
+		}
+		for exportName := range b.config.Exports {
+			if property == qualifiedExportName(b.name, exportName)+"."+propertyInject {
+				return true
+			}
+		}
+		return false
 	}
 }
 
+// isPermitted checks if any of the permissions declared by the bundle
+// grants access to the property path
+func (b *bundle) isPermitted(property string) bool {
+	for _, permission := range b.permissions {
+		if permission.grants(property) {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *bundle) Sandbox() Sandbox {
+	b.sandboxMutex.RLock()
+	defer b.sandboxMutex.RUnlock()
 	return b.sandbox
 }
 
@@ -156,9 +288,70 @@ func (b *bundle) getBasePath() string {
 	return b.basePath
 }
 
+func (b *bundle) getKernel() *kernel {
+	return b.kernel
+}
+
+// availableSandbox returns the sandbox of a bundle, which may be another
+// bundle stopped concurrently, or an error if it was released already
+func availableSandbox(bundle Bundle) (Sandbox, error) {
+	sandbox := bundle.Sandbox()
+	if sandbox == nil {
+		return nil, errors.New(fmt.Sprintf("bundle %s is no longer available", bundle.Name()))
+	}
+	return sandbox, nil
+}
+
+func (b *bundle) getEventLoop() *eventLoop {
+	b.sandboxMutex.RLock()
+	defer b.sandboxMutex.RUnlock()
+	return b.eventLoop
+}
+
 func (b *bundle) setBundleStatus(status BundleStatus) {
+	b.setBundleStatusWithCause(status, nil)
+}
+
+func (b *bundle) crash(err error) {
+	// Callbacks exceeding the execution limits are accounted by the
+	// bundle's execution budget, which fails the bundle on repeated
+	// violations. Limits exceeded by other bundles are uncaught errors.
+	if limit := findExecutionLimitError(err); limit != nil && b.getEventLoop().budget.accounted(limit) {
+		log.Warnf("Bundle: Callback of '%s' aborted: %s", b.Name(), limit.Error())
+		return
+	}
+	log.Errorf("Bundle: Uncaught error in callback of '%s': %s", b.Name(), err.Error())
+	b.kernel.bundleManager.__crashBundle(b, err)
+}
+
+func (b *bundle) setBundleFailed(failure *BundleFailure) {
+	b.statusMutex.Lock()
+	b.failure = failure
+	b.statusMutex.Unlock()
+	b.setBundleStatusWithCause(BundleStatusFailed, failure)
+}
+
+func (b *bundle) setBundleStatusWithCause(status BundleStatus, cause error) {
+	b.statusMutex.Lock()
+	oldStatus := b.status
 	b.status = status
-	log.Infof("Bundle: Status of '%s' changed to %s", b.Name(), status)
+	if status != BundleStatusFailed {
+		b.failure = nil
+	}
+	b.statusMutex.Unlock()
+	if cause != nil {
+		log.Infof("Bundle: Status of '%s' changed to %s: %s", b.Name(), status, cause.Error())
+	} else {
+		log.Infof("Bundle: Status of '%s' changed to %s", b.Name(), status)
+	}
+
+	b.kernel.statusListeners.fire(BundleStatusEvent{
+		Bundle:    b,
+		BundleID:  b.id,
+		OldStatus: oldStatus,
+		NewStatus: status,
+		Cause:     cause,
+	})
 }
 
 func (b *bundle) NewObject() Object {
@@ -182,6 +375,24 @@ func (b *bundle) removeModule(module *module) {
 	}
 }
 
+// __moduleExports returns the exports of the modules owned by the bundle,
+// exports of other bundles' modules are accounted by their owners. Bound
+// values are returned as well, the exports only hold accessors to them.
+func (b *bundle) __moduleExports() []Object {
+	exports := make([]Object, 0, len(b.modules))
+	for _, module := range b.modules {
+		if module.bundle.ID() == b.id && module.exports != nil {
+			exports = append(exports, module.exports)
+			for _, value := range module.bindings {
+				if value != nil && value.IsObject() {
+					exports = append(exports, value.ToObject())
+				}
+			}
+		}
+	}
+	return exports
+}
+
 func (b *bundle) pushLoaderStack(element string) {
 	b.loaderStack = append(b.loaderStack, element)
 }
@@ -204,6 +415,20 @@ func (b *bundle) peekLoaderStack() string {
 	return b.loaderStack[len(b.loaderStack)-1]
 }
 
+// __systemImport implements System.import(specifier[, importerId]), the
+// id of the importing module is the "id" of its System.register context
+func (b *bundle) __systemImport(call FunctionCall) Value {
+	if len(call.Arguments) < 1 {
+		return b.NewTypeError("illegal number of arguments")
+	}
+
+	importerId := ""
+	if len(call.Arguments) > 1 && call.Argument(1).IsDefined() {
+		importerId = call.Argument(1).String()
+	}
+	return b.kernel.__importModule(b, importerId, call.Argument(0).String())
+}
+
 func (b *bundle) __systemRegister(call FunctionCall) Value {
 	var module *module = nil
 	if len(b.loaderStack) > 0 {
@@ -236,16 +461,21 @@ func (b *bundle) __systemRegister(call FunctionCall) Value {
 		dependencies[i] = deps[i].(string)
 	}
 
-	var callback func(export func(name string, value Value), context Object) Object
-	err := b.sandbox.Export(call.Argument(argIndex), &callback)
-	if err != nil {
-		panic(err)
+	if module.state != moduleLoading {
+		panic(errors.New(fmt.Sprintf("module %s is already registered", module.Name())))
 	}
 
-	err = b.kernel.registerModule(module, dependencies, callback, b)
+	var declaration moduleDeclaration
+	err := b.sandbox.Export(call.Argument(argIndex), &declaration)
 	if err != nil {
 		panic(err)
 	}
 
+	// The module is linked and executed by the kernel after the whole
+	// module graph is registered
+	module.dependencies = dependencies
+	module.declaration = declaration
+	module.state = moduleRegistered
+
 	return b.Null()
 }
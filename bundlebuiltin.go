@@ -3,6 +3,8 @@ package gomini
 import (
 	"github.com/apex/log"
 	"strings"
+	"time"
+	"github.com/go-errors/errors"
 )
 
 func consoleApi() ApiProviderBinder {
@@ -42,11 +44,135 @@ func consoleApi() ApiProviderBinder {
 func timeoutApi() ApiProviderBinder {
 	return func(kernel Bundle, bundle Bundle, builder ObjectCreator) {
 		builder.DefineFunction("setTimeout", "setTimeout", func(call FunctionCall) Value {
-			return bundle.Null()
+			return setTimer(bundle, call, false)
+
+		}).DefineFunction("setInterval", "setInterval", func(call FunctionCall) Value {
+			return setTimer(bundle, call, true)
+
+		}).DefineFunction("setImmediate", "setImmediate", func(call FunctionCall) Value {
+			callback, err := newTimerCallback(bundle, call, 1)
+			if err != nil {
+				return bundle.NewTypeError(err.Error())
+			}
+			return bundle.ToValue(bundle.getEventLoop().setImmediate(callback))
+
+		}).DefineFunction("clearTimeout", "clearTimeout", func(call FunctionCall) Value {
+			return clearTimer(bundle, call)
+
+		}).DefineFunction("clearInterval", "clearInterval", func(call FunctionCall) Value {
+			return clearTimer(bundle, call)
+
+		}).DefineFunction("clearImmediate", "clearImmediate", func(call FunctionCall) Value {
+			return clearTimer(bundle, call)
 		})
 	}
 }
 
+func setTimer(bundle Bundle, call FunctionCall, repeat bool) Value {
+	callback, err := newTimerCallback(bundle, call, 2)
+	if err != nil {
+		return bundle.NewTypeError(err.Error())
+	}
+
+	var delay time.Duration
+	if len(call.Arguments) > 1 {
+		delay = time.Duration(call.Argument(1).ToInteger()) * time.Millisecond
+	}
+
+	return bundle.ToValue(bundle.getEventLoop().setTimer(delay, repeat, callback))
+}
+
+func clearTimer(bundle Bundle, call FunctionCall) Value {
+	if len(call.Arguments) > 0 && call.Argument(0).IsDefined() {
+		bundle.getEventLoop().clearTimer(call.Argument(0).ToInteger())
+	}
+	return bundle.Undefined()
+}
+
+// newTimerCallback creates the callback of a timer, the function is
+// passed as first argument, additional arguments start at the given index.
+func newTimerCallback(bundle Bundle, call FunctionCall, argumentsIndex int) (func(), error) {
+	if len(call.Arguments) < 1 {
+		return nil, errors.New("illegal number of arguments")
+	}
+
+	var callback Callable
+	if err := bundle.Export(call.Argument(0), &callback); err != nil {
+		return nil, errors.New("illegal parameter type")
+	}
+
+	arguments := make([]Value, 0)
+	if len(call.Arguments) > argumentsIndex {
+		arguments = append(arguments, call.Arguments[argumentsIndex:]...)
+	}
+
+	return func() {
+		if _, err := callback(bundle.Undefined(), arguments...); err != nil {
+			bundle.crash(err)
+		}
+	}, nil
+}
+
+func bundlesApi() ApiProviderBinder {
+	return func(kernel Bundle, bundle Bundle, builder ObjectCreator) {
+		bundlesBuilder := func(builder ObjectBuilder) {
+			builder.DefineFunction("onStatusChange", "onStatusChange", func(call FunctionCall) Value {
+				if len(call.Arguments) < 1 {
+					return bundle.NewTypeError("illegal number of arguments")
+				}
+
+				var callback Callable
+				if err := bundle.Export(call.Argument(0), &callback); err != nil {
+					return bundle.NewTypeError("illegal parameter type")
+				}
+
+				eventLoop := bundle.getEventLoop()
+				remove := addBundleStatusListener(kernel, bundle, func(event BundleStatusEvent) {
+					// Unprivileged bundles only see their own status transitions
+					if !bundle.Privileged() && event.BundleID != bundle.ID() {
+						return
+					}
+
+					// Listeners are notified on the bundle's event loop
+					eventLoop.submit(func() {
+						if _, err := callback(bundle.Undefined(), newBundleStatusEventObject(bundle, event)); err != nil {
+							bundle.crash(err)
+						}
+					})
+				})
+
+				return bundle.ToValue(func() {
+					remove()
+				})
+			})
+		}
+
+		builder.DefineObjectProperty("bundles", bundlesBuilder)
+	}
+}
+
+func addBundleStatusListener(kernelBundle Bundle, owner Bundle, listener BundleStatusListener) func() {
+	return kernelBundle.(*kernel).statusListeners.add(owner.ID(), listener)
+}
+
+func newBundleStatusEventObject(bundle Bundle, event BundleStatusEvent) Object {
+	object := bundle.NewObject()
+	object.DefineConstant("bundleId", event.BundleID)
+	if event.Bundle != nil {
+		object.DefineConstant("bundleName", event.Bundle.Name())
+	} else {
+		object.DefineConstant("bundleName", event.BundleID)
+	}
+	object.DefineConstant("oldStatus", event.OldStatus.String())
+	object.DefineConstant("newStatus", event.NewStatus.String())
+	if event.Cause != nil {
+		object.DefineConstant("cause", event.Cause.Error())
+	} else {
+		object.DefineConstant("cause", bundle.Null())
+	}
+	return object.Freeze()
+}
+
 var emptyStackFrame = _emptyStackFrame{}
 
 type _emptyStackFrame struct{}
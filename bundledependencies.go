@@ -0,0 +1,133 @@
+package gomini
+
+import (
+	"fmt"
+	"strings"
+	"github.com/go-errors/errors"
+)
+
+// bundleRequirement is a single entry of the "requires" section of the
+// bundle.json. Version is a version range the required bundle has to match.
+type bundleRequirement struct {
+	Id      string `json:"id"`
+	Version string `json:"version"`
+
+	versionRange *versionRange
+}
+
+func (b *bundle) requirements() []bundleRequirement {
+	if b.config == nil {
+		return nil
+	}
+	return b.config.Requires
+}
+
+func (b *bundle) requires(id string) bool {
+	for _, requirement := range b.requirements() {
+		if requirement.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// __resolveStartOrder orders all installed bundles so that every bundle
+// comes after the bundles it requires. Bundles being part of a dependency
+// cycle cannot be started and are returned with the cycle as error.
+func (bm *bundleManager) __resolveStartOrder() ([]*bundle, map[*bundle]error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	order := make([]*bundle, 0, len(bm.bundles))
+	cycles := make(map[*bundle]error)
+	state := make(map[*bundle]int)
+	path := make([]*bundle, 0)
+
+	var visit func(bundle *bundle)
+	visit = func(bundle *bundle) {
+		switch state[bundle] {
+		case visited:
+			return
+		case visiting:
+			start := 0
+			for i, element := range path {
+				if element == bundle {
+					start = i
+					break
+				}
+			}
+			ids := make([]string, 0, len(path)-start+1)
+			for _, element := range path[start:] {
+				ids = append(ids, element.ID())
+			}
+			ids = append(ids, bundle.ID())
+
+			err := errors.New(fmt.Sprintf("dependency cycle detected: %s", strings.Join(ids, " -> ")))
+			for _, element := range path[start:] {
+				cycles[element] = err
+			}
+			return
+		}
+
+		state[bundle] = visiting
+		path = append(path, bundle)
+		for _, requirement := range bundle.requirements() {
+			if dependency := bm.findBundleById(requirement.Id); dependency != nil {
+				visit(dependency)
+			}
+		}
+		path = path[:len(path)-1]
+		state[bundle] = visited
+		order = append(order, bundle)
+	}
+
+	for _, bundle := range bm.bundles {
+		visit(bundle)
+	}
+	return order, cycles
+}
+
+// __checkRequirements makes sure all required bundles are installed,
+// match the requested version range and are started.
+func (bm *bundleManager) __checkRequirements(bundle *bundle) error {
+	for _, requirement := range bundle.requirements() {
+		dependency := bm.findBundleById(requirement.Id)
+		if dependency == nil {
+			return errors.New(fmt.Sprintf("required bundle %s is not installed", requirement.Id))
+		}
+		if requirement.versionRange != nil && !requirement.versionRange.matches(dependency.Version()) {
+			return errors.New(fmt.Sprintf("required bundle %s is installed in version %s, but %s is required",
+				requirement.Id, dependency.Version(), requirement.Version))
+		}
+		if dependency.Status() != BundleStatusStarted {
+			return errors.New(fmt.Sprintf("required bundle %s is not started (%s)", requirement.Id, dependency.Status()))
+		}
+	}
+	return nil
+}
+
+// __startedDependents returns all started bundles which directly or
+// transitively require the given bundle, in the order they need to be
+// started in.
+func (bm *bundleManager) __startedDependents(target *bundle) []*bundle {
+	order, _ := bm.__resolveStartOrder()
+
+	affected := map[*bundle]bool{target: true}
+	dependents := make([]*bundle, 0)
+	for _, candidate := range order {
+		if candidate.Status() != BundleStatusStarted {
+			continue
+		}
+		for _, requirement := range candidate.requirements() {
+			if dependency := bm.findBundleById(requirement.Id); dependency != nil && affected[dependency] {
+				affected[candidate] = true
+				dependents = append(dependents, candidate)
+				break
+			}
+		}
+	}
+	return dependents
+}
@@ -0,0 +1,108 @@
+package gomini_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+)
+
+// reportingIndex reports the given value when the bundle starts
+func reportingIndex(value string) string {
+	return `
+		System.register([], function (exports_1) {
+			return {
+				setters: [],
+				execute: function () {
+					report("` + value + `");
+				}
+			};
+		});
+	`
+}
+
+func requires(requirements ...map[string]string) map[string]interface{} {
+	return map[string]interface{}{"requires": requirements}
+}
+
+func TestBundlesStartAfterTheirRequirements(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	// Bundles are found in alphabetical order, which is the reverse order
+	// of their requirements
+	tk.writeBundle("a", requires(map[string]string{"id": "b"}), map[string]string{"index.ts": reportingIndex("a")})
+	tk.writeBundle("b", requires(map[string]string{"id": "c"}), map[string]string{"index.ts": reportingIndex("b")})
+	tk.writeBundle("c", nil, map[string]string{"index.ts": reportingIndex("c")})
+	tk.start()
+
+	tk.expectReports("c", "b", "a")
+}
+
+func TestDependencyCyclesFailTheBundles(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("x", requires(map[string]string{"id": "y"}), map[string]string{"index.ts": reportingIndex("x")})
+	tk.writeBundle("y", requires(map[string]string{"id": "x"}), map[string]string{"index.ts": reportingIndex("y")})
+	tk.writeBundle("z", nil, map[string]string{"index.ts": reportingIndex("z")})
+	tk.start()
+
+	tk.expectReports("z")
+	for _, id := range []string{"x", "y"} {
+		bundle := tk.awaitStatus(id, gomini.BundleStatusFailed)
+		if bundle.Failure() == nil || !strings.Contains(bundle.Failure().Error(), "dependency cycle") {
+			t.Fatalf("unexpected failure of %s: %v", id, bundle.Failure())
+		}
+	}
+}
+
+func TestMissingRequirementsFailTheBundle(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("missing", requires(map[string]string{"id": "unknown"}),
+		map[string]string{"index.ts": reportingIndex("missing")})
+	tk.start()
+
+	missing := tk.awaitStatus("missing", gomini.BundleStatusFailed)
+	if !strings.Contains(missing.Failure().Error(), "unknown is not installed") {
+		t.Fatalf("unexpected failure %s", missing.Failure())
+	}
+	tk.expectNoReport(50 * time.Millisecond)
+}
+
+func TestRequiredVersionRanges(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("lib", map[string]interface{}{"version": "1.4.0"}, map[string]string{"index.ts": reportingIndex("lib")})
+	tk.writeBundle("compatible", requires(map[string]string{"id": "lib", "version": "^1.2"}),
+		map[string]string{"index.ts": reportingIndex("compatible")})
+	tk.writeBundle("incompatible", requires(map[string]string{"id": "lib", "version": ">=2.0.0"}),
+		map[string]string{"index.ts": reportingIndex("incompatible")})
+	tk.start()
+
+	tk.expectReports("lib", "compatible")
+	tk.expectNoReport(50 * time.Millisecond)
+
+	incompatible := tk.awaitStatus("incompatible", gomini.BundleStatusFailed)
+	if !strings.Contains(incompatible.Failure().Error(), "installed in version 1.4.0") {
+		t.Fatalf("unexpected failure %s", incompatible.Failure())
+	}
+}
+
+func TestDependentsFollowTheirRequirements(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", requires(map[string]string{"id": "lib"}), map[string]string{"index.ts": reportingIndex("app")})
+	tk.writeBundle("lib", nil, map[string]string{"index.ts": reportingIndex("lib")})
+	tk.start()
+	tk.expectReports("lib", "app")
+
+	if err := tk.RestartBundle("lib"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("lib", "app")
+
+	if err := tk.StopBundle("lib"); err != nil {
+		t.Fatal(err)
+	}
+	tk.awaitStatus("app", gomini.BundleStatusStopped)
+
+	// Bundles cannot be started without their requirements
+	if err := tk.StartBundle("app"); err == nil {
+		t.Fatal("bundle started without its requirement")
+	}
+}
@@ -0,0 +1,300 @@
+package gomini
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/go-errors/errors"
+	"github.com/spf13/afero"
+	"github.com/apex/log"
+)
+
+const checksumPrefix = "sha256:"
+
+var errNoRepository = errors.New("no bundle repository configured")
+
+func (bm *bundleManager) repositoryBundles() ([]RepositoryBundle, error) {
+	repository := bm.kernel.kernelConfig.Repository
+	if repository == nil {
+		return nil, errNoRepository
+	}
+	return repository.Bundles()
+}
+
+// installFromRepository downloads and installs the newest version of the
+// bundle matching the version range. The download happens without
+// holding the lock, the bundle is not started automatically.
+func (bm *bundleManager) installFromRepository(id, version string) (*bundle, error) {
+	selected, err := bm.__selectRepositoryBundle(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := bm.__download(selected, BundleStatusStopped)
+	if err != nil {
+		return nil, err
+	}
+	defer bm.kernel.filesystem.RemoveAll(path)
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	if bm.findBundleById(id) != nil {
+		return nil, errors.New(fmt.Sprintf("bundle %s is already installed", id))
+	}
+
+	target := filepath.Join(KernelVfsAppsPath, id+archiveExtension(path))
+	if fileExists(bm.kernel.filesystem, target) {
+		return nil, errors.New(fmt.Sprintf("bundle path kernel:/%s already exists", target))
+	}
+	// Copied instead of renamed, afero.MemMapFs only renames the directory
+	// itself but not its contents
+	if err := copyPath(bm.kernel.filesystem, path, target); err != nil {
+		bm.kernel.filesystem.RemoveAll(target)
+		return nil, errors.New(err)
+	}
+
+	bundle, err := bm.__installBundle(target)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("BundleManager: Installed bundle %s in version %s from repository", bundle.Name(), bundle.Version())
+	return bundle, nil
+}
+
+// updateFromRepository updates the installed bundle to the newest version
+// matching the version range, if that version is newer than the
+// installed one.
+func (bm *bundleManager) updateFromRepository(id, version string, options BundleUpdateOptions) error {
+	installed := bm.lookupBundle(id)
+	if installed == nil {
+		return errUnknownBundle
+	}
+
+	selected, err := bm.__selectRepositoryBundle(id, version)
+	if err != nil {
+		return err
+	}
+
+	candidate, err := ParseVersion(selected.Version)
+	if err != nil {
+		return err
+	}
+	if !candidate.NewerThan(installed.Version()) {
+		log.Infof("BundleManager: Bundle %s is up to date in version %s", installed.Name(), installed.Version())
+		return nil
+	}
+
+	path, err := bm.__download(selected, installed.Status())
+	if err != nil {
+		return err
+	}
+	defer bm.kernel.filesystem.RemoveAll(path)
+
+	return bm.updateBundle(id, path, options)
+}
+
+// __selectRepositoryBundle returns the newest repository bundle with the
+// given id and a version matching the version range.
+func (bm *bundleManager) __selectRepositoryBundle(id, version string) (RepositoryBundle, error) {
+	bundles, err := bm.repositoryBundles()
+	if err != nil {
+		return RepositoryBundle{}, err
+	}
+
+	var versionRange *versionRange
+	if version != "" {
+		if versionRange, err = parseVersionRange(version); err != nil {
+			return RepositoryBundle{}, err
+		}
+	}
+
+	var selected *RepositoryBundle
+	var selectedVersion Version
+	for i, candidate := range bundles {
+		if candidate.ID != id {
+			continue
+		}
+
+		candidateVersion, err := ParseVersion(candidate.Version)
+		if err != nil {
+			log.Warnf("BundleManager: Ignoring repository bundle %s with illegal version %s", id, candidate.Version)
+			continue
+		}
+		if versionRange != nil && !versionRange.matches(candidateVersion) {
+			continue
+		}
+
+		if selected == nil || candidateVersion.NewerThan(selectedVersion) {
+			selected = &bundles[i]
+			selectedVersion = candidateVersion
+		}
+	}
+
+	if selected == nil {
+		return RepositoryBundle{}, errors.New(fmt.Sprintf("no version of bundle %s matching '%s' found in repository", id, version))
+	}
+	return *selected, nil
+}
+
+// __download downloads and verifies the archive of the repository bundle
+// into the downloads path and returns the path of the unpacked bundle.
+// The download is reported as BundleStatusDownloading transition, the
+// previous status is reported again after the download finished.
+func (bm *bundleManager) __download(selected RepositoryBundle, status BundleStatus) (path string, err error) {
+	bm.__fireDownloadStatus(selected.ID, status, BundleStatusDownloading, nil)
+	defer func() {
+		bm.__fireDownloadStatus(selected.ID, BundleStatusDownloading, status, err)
+	}()
+
+	extension := archiveExtension(selected.URL)
+	if extension == "" {
+		return "", errors.New(fmt.Sprintf("unsupported archive type of bundle %s: %s", selected.ID, selected.URL))
+	}
+
+	filesystem := bm.kernel.filesystem
+	if err := filesystem.MkdirAll(KernelVfsDownloadsPath, os.ModePerm); err != nil {
+		return "", errors.New(err)
+	}
+
+	// Leftovers of previous downloads are removed before downloading, the
+	// archive's name has the path as prefix and afero.MemMapFs removes all
+	// files by prefix
+	path = filepath.Join(KernelVfsDownloadsPath, selected.ID+"-"+selected.Version)
+	archive := path + extension
+	for _, leftover := range []string{archive, path} {
+		if err := filesystem.RemoveAll(leftover); err != nil {
+			return "", errors.New(err)
+		}
+	}
+
+	log.Infof("BundleManager: Downloading bundle %s in version %s from %s", selected.ID, selected.Version, selected.URL)
+	if err := bm.__downloadArchive(selected, archive); err != nil {
+		filesystem.Remove(archive)
+		return "", err
+	}
+
+	if extension == ".bacc" {
+		return archive, nil
+	}
+
+	defer filesystem.Remove(archive)
+	if err := extractTarGz(filesystem, archive, path); err != nil {
+		filesystem.RemoveAll(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func (bm *bundleManager) __downloadArchive(selected RepositoryBundle, archive string) error {
+	if !strings.HasPrefix(selected.Checksum, checksumPrefix) {
+		return errors.New(fmt.Sprintf("unsupported checksum of bundle %s: %s", selected.ID, selected.Checksum))
+	}
+
+	reader, err := bm.kernel.kernelConfig.Repository.Open(selected)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := bm.kernel.filesystem.Create(archive)
+	if err != nil {
+		return errors.New(err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hasher), reader); err != nil {
+		return errors.New(err)
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	expected := strings.ToLower(strings.TrimPrefix(selected.Checksum, checksumPrefix))
+	if checksum != expected {
+		return errors.New(fmt.Sprintf("checksum mismatch of bundle %s: expected %s, got %s", selected.ID, expected, checksum))
+	}
+	return nil
+}
+
+// __fireDownloadStatus reports download progress of a bundle, which is
+// either not yet installed or keeps running while the update downloads.
+// Therefore the events carry the bundle id only.
+func (bm *bundleManager) __fireDownloadStatus(id string, oldStatus, newStatus BundleStatus, cause error) {
+	bm.kernel.statusListeners.fire(BundleStatusEvent{
+		BundleID:  id,
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		Cause:     cause,
+	})
+}
+
+func archiveExtension(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".bacc"):
+		return ".bacc"
+	case strings.HasSuffix(path, ".tar.gz"):
+		return ".tar.gz"
+	case strings.HasSuffix(path, ".tgz"):
+		return ".tgz"
+	}
+	return ""
+}
+
+// extractTarGz extracts the directories and regular files of the archive
+// into the target directory, entries escaping the target are rejected.
+func extractTarGz(filesystem afero.Fs, archive, target string) error {
+	file, err := filesystem.Open(archive)
+	if err != nil {
+		return errors.New(err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.New(err)
+	}
+	defer gzipReader.Close()
+
+	if err := filesystem.MkdirAll(target, os.ModePerm); err != nil {
+		return errors.New(err)
+	}
+
+	reader := tar.NewReader(gzipReader)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New(err)
+		}
+
+		// Rooting the entry name prevents entries from escaping the target
+		destination := filepath.Join(target, filepath.Clean("/"+header.Name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := filesystem.MkdirAll(destination, os.ModePerm); err != nil {
+				return errors.New(err)
+			}
+
+		case tar.TypeReg:
+			if err := filesystem.MkdirAll(filepath.Dir(destination), os.ModePerm); err != nil {
+				return errors.New(err)
+			}
+			if err := afero.WriteReader(filesystem, destination, reader); err != nil {
+				return errors.New(err)
+			}
+
+		default:
+			log.Warnf("BundleManager: Ignoring unsupported archive entry %s", header.Name)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package gomini
 import (
 	"path/filepath"
 	"os"
+	"fmt"
+	"sync"
 	"github.com/go-errors/errors"
 	"github.com/spf13/afero"
 	"github.com/apex/log"
@@ -10,62 +12,338 @@ import (
 
 const (
 	bundleJson = "bundle.json"
-	jsPromise  = "/js/kernel/promise.js"
 )
 
 var errNoSuchBundle = errors.New("the given path is not a bundle")
+var errUnknownBundle = errors.New("no bundle with the given id is installed")
 
 func newBundleManager(kernel *kernel, apiBinders []ApiProviderBinder) *bundleManager {
-	return &bundleManager{
+	bundleManager := &bundleManager{
 		kernel:     kernel,
 		apiBinders: apiBinders,
+		updating:   make(map[string]bool),
 	}
+	bundleManager.supervisor = newBundleSupervisor(bundleManager)
+	kernel.statusListeners.add("", bundleManager.supervisor.onStatusChange)
+	return bundleManager
 }
 
 type bundleManager struct {
 	kernel     *kernel
 	apiBinders []ApiProviderBinder
+	bundles    []*bundle
+	supervisor *bundleSupervisor
+	mutex      sync.Mutex
+	updating   map[string]bool
 }
 
 func (bm *bundleManager) start() error {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
 	transpiler, err := newTranspiler(bm.kernel)
 	if err != nil {
 		return err
 	}
 
-	return afero.Walk(bm.kernel.filesystem, KernelVfsAppsPath, func(path string, info os.FileInfo, err error) error {
+	bm.__reconcileSlots()
+
+	err = afero.Walk(bm.kernel.filesystem, KernelVfsAppsPath, func(path string, info os.FileInfo, err error) error {
 		if path == KernelVfsAppsPath {
 			return nil
 		}
 
 		bundle, err := bm.__tryLoadBundle(path, info, transpiler)
 
-		if err != nil {
-			if err != filepath.SkipDir {
-				log.Warnf("BundleManager: Loading bundle from path %s failed: %s", path, err.Error())
-				return nil
-			}
+		if err == filepath.SkipDir {
 			return err
 		}
 
+		if err != nil {
+			log.Warnf("BundleManager: Loading bundle from path %s failed: %s", path, err.Error())
+		}
+
 		if bundle == nil {
 			return nil
 		}
 
 		log.Infof("BundleManager: Loaded bundle %s", bundle.Name())
 
-		if info != nil {
+		// Skipping the remaining entries of archive bundles' parents isn't wanted
+		if info != nil && info.IsDir() {
 			return filepath.SkipDir
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	// Start bundles after their requirements
+	order, cycles := bm.__resolveStartOrder()
+	for _, bundle := range order {
+		if bundle.Status() != BundleStatusInstalled {
+			continue
+		}
+
+		if err := cycles[bundle]; err != nil {
+			bm.__failBundle(bundle, err)
+			continue
+		}
+
+		if err := bm.startBundle(bundle); err != nil {
+			log.Warnf("BundleManager: Starting bundle %s failed: %s", bundle.Name(), err.Error())
+		}
+	}
+	return nil
 }
 
 func (bm *bundleManager) stop() error {
-	// TODO: bundleManager needs to be able to be stopped
+	bm.supervisor.stop()
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	// Stop bundles in reverse order of their requirements
+	order, _ := bm.__resolveStartOrder()
+	for i := len(order) - 1; i >= 0; i-- {
+		bundle := order[i]
+		if err := bm.stopBundle(bundle); err != nil {
+			log.Warnf("BundleManager: Stopping bundle %s failed: %s", bundle.Name(), err.Error())
+		}
+	}
 	return nil
 }
 
+func (bm *bundleManager) startBundle(bundle *bundle) error {
+	switch bundle.Status() {
+	case BundleStatusStarted, BundleStatusStarting:
+		return nil
+	case BundleStatusStopping:
+		return errors.New(fmt.Sprintf("bundle %s is currently stopping", bundle.Name()))
+	}
+
+	if bundle.config == nil {
+		return errors.New(fmt.Sprintf("bundle configuration of %s could not be loaded", bundle.Name()))
+	}
+
+	if err := bm.__checkRequirements(bundle); err != nil {
+		bm.__failBundle(bundle, err)
+		return err
+	}
+
+	if bundle.sandbox == nil {
+		bundle.newSandbox()
+	}
+
+	// The kernel waits for the bundle's scripts while holding the bundle
+	// manager, therefore they are limited by the lifecycle timeout
+	timeout := bm.kernel.kernelConfig.LifecycleTimeout
+	err := bundle.eventLoop.executeWithin(timeout, func() error {
+		return bundle.init(bm.kernel)
+	})
+	if err != nil {
+		bm.__failBundle(bundle, err)
+		return err
+	}
+
+	bundle.setBundleStatus(BundleStatusStarting)
+	err = bundle.eventLoop.executeWithin(timeout, func() error {
+		if err := bm.__tryLoadEntrypoint(bundle); err != nil {
+			return err
+		}
+		// Bundles exceeding their memory limit while loading aren't started
+		return bundle.eventLoop.budget.checkMemory(true)
+	})
+	if err != nil {
+		bm.__failBundle(bundle, err)
+		return err
+	}
+
+	bundle.setBundleStatus(BundleStatusStarted)
+	return nil
+}
+
+func (bm *bundleManager) stopBundle(bundle *bundle) error {
+	switch bundle.Status() {
+	case BundleStatusStopped, BundleStatusStopping, BundleStatusInstalled:
+		return nil
+	}
+
+	bundle.setBundleStatus(BundleStatusStopping)
+
+	// Bundles requiring this bundle cannot keep running without it
+	for _, dependent := range bm.bundles {
+		if dependent.Status() == BundleStatusStarted && dependent.requires(bundle.ID()) {
+			if err := bm.stopBundle(dependent); err != nil {
+				log.Warnf("BundleManager: Stopping dependent bundle %s failed: %s", dependent.Name(), err.Error())
+			}
+		}
+	}
+
+	// Failed bundles already released their sandbox
+	if bundle.sandbox != nil {
+		err := bundle.eventLoop.executeWithin(bm.kernel.kernelConfig.LifecycleTimeout, func() error {
+			return bm.__tryCallStopHook(bundle)
+		})
+		if err != nil {
+			log.Warnf("BundleManager: onStop hook of bundle %s failed: %s", bundle.Name(), err.Error())
+		}
+
+		// Cancels all pending timers
+		bundle.releaseSandbox()
+	}
+
+	bundle.setBundleStatus(BundleStatusStopped)
+	return nil
+}
+
+func (bm *bundleManager) restartBundle(bundle *bundle) error {
+	// Dependents are stopped together with the bundle and restarted afterwards
+	dependents := bm.__startedDependents(bundle)
+
+	if err := bm.stopBundle(bundle); err != nil {
+		return err
+	}
+	if err := bm.startBundle(bundle); err != nil {
+		return err
+	}
+
+	for _, dependent := range dependents {
+		if err := bm.startBundle(dependent); err != nil {
+			log.Warnf("BundleManager: Restarting dependent bundle %s failed: %s", dependent.Name(), err.Error())
+		}
+	}
+	return nil
+}
+
+func (bm *bundleManager) uninstallBundle(bundle *bundle) error {
+	if err := bm.stopBundle(bundle); err != nil {
+		return err
+	}
+
+	bm.removeBundle(bundle)
+	bundle.releaseIoPool()
+
+	if err := bm.kernel.filesystem.RemoveAll(bundle.getBasePath()); err != nil {
+		return errors.New(err)
+	}
+	if err := bm.kernel.filesystem.RemoveAll(bundleDataSectionPath(bundle.ID())); err != nil {
+		return errors.New(err)
+	}
+	if err := bm.__removeSlotMarker(bundle.ID()); err != nil {
+		return err
+	}
+
+	log.Infof("BundleManager: Uninstalled bundle %s", bundle.Name())
+	return nil
+}
+
+func (bm *bundleManager) installBundle(path string) (*bundle, error) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bundle, err := bm.__installBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("BundleManager: Installed bundle %s", bundle.Name())
+	return bundle, nil
+}
+
+func (bm *bundleManager) __installBundle(path string) (*bundle, error) {
+	info, err := bm.kernel.filesystem.Stat(path)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	transpiler, err := newTranspiler(bm.kernel)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := bm.__tryLoadBundle(path, info, transpiler)
+	if err == filepath.SkipDir || (err == nil && bundle == nil) {
+		return nil, errNoSuchBundle
+	}
+	return bundle, err
+}
+
+func (bm *bundleManager) listBundles() []Bundle {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bundles := make([]Bundle, len(bm.bundles))
+	for i, bundle := range bm.bundles {
+		bundles[i] = bundle
+	}
+	return bundles
+}
+
+func (bm *bundleManager) lookupBundle(id string) Bundle {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	if bundle := bm.findBundleById(id); bundle != nil {
+		return bundle
+	}
+	return nil
+}
+
+// withBundle runs the given lifecycle operation against the
+// installed bundle with the given id while holding the lock.
+func (bm *bundleManager) withBundle(id string, operation func(bundle *bundle) error) error {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	bundle := bm.findBundleById(id)
+	if bundle == nil {
+		return errUnknownBundle
+	}
+	return operation(bundle)
+}
+
+func (bm *bundleManager) findBundleById(id string) *bundle {
+	for _, bundle := range bm.bundles {
+		if bundle.ID() == id {
+			return bundle
+		}
+	}
+	return nil
+}
+
+func (bm *bundleManager) findBundleByName(name string) *bundle {
+	for _, bundle := range bm.bundles {
+		if bundle.Name() == name {
+			return bundle
+		}
+	}
+	return nil
+}
+
+func (bm *bundleManager) addBundle(bundle *bundle) {
+	bm.bundles = append(bm.bundles, bundle)
+}
+
+func (bm *bundleManager) removeBundle(bundle *bundle) {
+	for i, el := range bm.bundles {
+		if el == bundle {
+			bm.bundles = append(bm.bundles[:i], bm.bundles[i+1:]...)
+			break
+		}
+	}
+}
+
+func (bm *bundleManager) replaceBundle(old, new *bundle) {
+	for i, el := range bm.bundles {
+		if el == old {
+			bm.bundles[i] = new
+			break
+		}
+	}
+}
+
 func (bm *bundleManager) registerDefaults(bundle Bundle) error {
 	for _, binder := range bm.apiBinders {
 		objectBuilder := bundle.Sandbox().NewObjectCreator("")
@@ -73,9 +351,9 @@ func (bm *bundleManager) registerDefaults(bundle Bundle) error {
 		objectBuilder.BuildInto("", bundle.Sandbox().Global())
 	}
 
-	if _, err := loadPlainJavascript(bm.kernel, jsPromise, bm.kernel, bundle); err != nil {
-		return err
+	// Transpiled scripts expect the TypeScript helpers as globals
+	if _, err := bundle.Sandbox().Execute(bm.kernel.tsHelpers); err != nil {
+		return errors.New(err)
 	}
-
 	return nil
 }
@@ -8,14 +8,31 @@ import (
 	"github.com/relationsone/bacc"
 	"io/ioutil"
 	"encoding/json"
+	"fmt"
+	"time"
 	"github.com/apex/log"
 )
 
+const (
+	// privilegeDataSection grants a bundle its writable data section
+	privilegeDataSection = "PRIVILEGE_DATA"
+	bundleDataPath       = "/data"
+)
+
 type bundleConfig struct {
-	Id         string   `json:"id"`
-	Name       string   `json:"name"`
-	Entrypoint string   `json:"entrypoint"`
-	Privileges []string `json:"privileges"`
+	Id          string              `json:"id"`
+	Name        string              `json:"name"`
+	Version     string              `json:"version"`
+	Entrypoint  string              `json:"entrypoint"`
+	Privileges  []string            `json:"privileges"`
+	Permissions []string            `json:"permissions"`
+	Restart     *restartConfig      `json:"restart"`
+	Requires    []bundleRequirement `json:"requires"`
+	Exports     map[string]string   `json:"exports"`
+	Target      string              `json:"target"`
+	Limits      *limitsConfig       `json:"limits"`
+
+	version Version
 }
 
 func (bm *bundleManager) __bindModuleToKernelSyscall(module Module) KernelSyscall {
@@ -24,44 +41,184 @@ func (bm *bundleManager) __bindModuleToKernelSyscall(module Module) KernelSyscal
 	}
 }
 
-func (bm *bundleManager) __newBundle(path string, bundlefs afero.Fs, transpiler *transpiler) (Bundle, error) {
+func (bm *bundleManager) __newBundle(path string, bundlefs afero.Fs, transpiler *transpiler) (*bundle, error) {
 	bundleFile := filepath.Join(path, bundleJson)
 	log.Infof("BundleManager: Loading new bundle from kernel:/%s", bundleFile)
+
+	config, configErr := bm.__readBundleConfig(bundlefs)
+
+	id := config.Id
+	if id == "" {
+		// Without a valid configuration the bundle is registered by its filename
+		id = filepath.Base(path)
+	}
+	name := config.Name
+	if name == "" {
+		name = id
+	}
+
+	if installed := bm.findBundleById(id); installed != nil {
+		return nil, errors.New(fmt.Sprintf("bundle with id %s is already installed in version %s (candidate: %s)",
+			id, installed.Version(), describeVersionChange(installed.Version(), config.version)))
+	}
+
+	bundle, err := newBundle(bm.kernel, path, bundlefs, id, name, config.Privileges)
+	if err != nil {
+		return nil, err
+	}
+	bm.addBundle(bundle)
+
+	if configErr == nil {
+		configErr = bm.__configureBundle(bundle, config)
+	}
+	if configErr != nil {
+		// Keep the bundle registered to make the failure reason retrievable
+		bm.__failBundle(bundle, configErr)
+		return bundle, configErr
+	}
+	return bundle, nil
+}
+
+// __configureBundle applies the bundle configuration, including the
+// restart policy and the limits capped by the kernel configuration.
+func (bm *bundleManager) __configureBundle(bundle *bundle, config *bundleConfig) error {
+	restartPolicy, err := newRestartPolicy(config.Restart)
+	if err != nil {
+		return err
+	}
+	limits, err := newBundleLimits(config.Limits, bm.kernel.kernelConfig.BundleLimits)
+	if err != nil {
+		return err
+	}
+	permissions, err := parsePermissions(config.Permissions)
+	if err != nil {
+		return err
+	}
+
+	bundle.config = config
+	bundle.version = config.version
+	bundle.restartPolicy = restartPolicy
+	bundle.limits = limits
+	bundle.permissions = permissions
+	if bundle.hasPrivilege(privilegeDataSection) {
+		if err := bm.__mountDataSection(bundle); err != nil {
+			return err
+		}
+	}
+	if limits.IOBandwidth > 0 {
+		bundle.throttleIO(limits.IOBandwidth)
+	}
+	return nil
+}
+
+func (bm *bundleManager) __readBundleConfig(bundlefs afero.Fs) (*bundleConfig, error) {
+	config := &bundleConfig{}
+
 	reader, err := bundlefs.Open(bundleJson)
 	if err != nil {
-		return nil, errors.New(err)
+		return config, errors.New(err)
 	}
 	defer reader.Close()
 
 	content, err := ioutil.ReadAll(reader)
 	if err != nil {
-		return nil, errors.New(err)
+		return config, errors.New(err)
 	}
 
-	config := bundleConfig{}
-	err = json.Unmarshal(content, &config)
-	if err != nil {
-		return nil, errors.New(err)
+	if err := json.Unmarshal(content, config); err != nil {
+		return config, errors.New(err)
 	}
 
-	bundle, err := newBundle(bm.kernel, path, bundlefs, config.Id, config.Name, config.Privileges)
-	if err != nil {
-		return nil, err
+	if config.Id == "" {
+		return config, errors.New("bundle configuration is missing the id")
+	}
+	if config.Entrypoint == "" {
+		return config, errors.New(fmt.Sprintf("bundle configuration of %s is missing the entrypoint", config.Id))
 	}
 
-	bundle.init(bm.kernel)
+	// Bundles without a version are treated as 0.0.0
+	if config.Version != "" {
+		version, err := ParseVersion(config.Version)
+		if err != nil {
+			return config, err
+		}
+		config.version = version
+	}
 
-	bundle.setBundleStatus(BundleStatusStarting)
-	_, err = bm.kernel.loadScriptModule(config.Id, config.Name, "/", &resolvedScriptPath{config.Entrypoint, bundle}, bundle)
-	if err != nil {
-		return nil, err
+	if config.Target != "" {
+		target, err := checkScriptTarget(config.Target, bm.kernel.sandbox.ScriptTarget())
+		if err != nil {
+			return config, err
+		}
+		config.Target = target
 	}
 
-	bundle.setBundleStatus(BundleStatusStarted)
-	return bundle, nil
+	for exportName, path := range config.Exports {
+		if exportName == "" || path == "" {
+			return config, errors.New(fmt.Sprintf("bundle configuration of %s has an illegal export", config.Id))
+		}
+	}
+
+	for i := range config.Requires {
+		requirement := &config.Requires[i]
+		if requirement.Id == "" || requirement.Id == config.Id {
+			return config, errors.New(fmt.Sprintf("bundle configuration of %s has an illegal requirement", config.Id))
+		}
+		if requirement.Version != "" {
+			versionRange, err := parseVersionRange(requirement.Version)
+			if err != nil {
+				return config, err
+			}
+			requirement.versionRange = versionRange
+		}
+	}
+
+	return config, nil
 }
 
-func (bm *bundleManager) __tryLoadBundle(path string, info os.FileInfo, transpiler *transpiler) (bundle Bundle, err error) {
+// __crashBundle moves a started bundle to BundleStatusFailed after one of
+// its script callbacks threw. As the callback may still be executing
+// inside the bundle's sandbox, the bundle is failed asynchronously.
+func (bm *bundleManager) __crashBundle(crashed *bundle, err error) {
+	go bm.withBundle(crashed.ID(), func(bundle *bundle) error {
+		if bundle != crashed || bundle.Status() != BundleStatusStarted {
+			return nil
+		}
+		bm.__failBundle(bundle, err)
+		return nil
+	})
+}
+
+// __failBundle records the failure reason, releases the bundle's
+// sandbox and moves the bundle to BundleStatusFailed.
+func (bm *bundleManager) __failBundle(bundle *bundle, err error) *BundleFailure {
+	failure := &BundleFailure{
+		Cause: err,
+		Time:  time.Now(),
+	}
+
+	if loadError := findModuleLoadError(err); loadError != nil {
+		failure.Cause = loadError.cause
+		failure.StackTrace = loadError.stackTrace
+		failure.ImportChain = loadError.importChain
+	} else if bundle.sandbox != nil {
+		failure.StackTrace = bundle.sandbox.ErrorStackTrace(err)
+		failure.ImportChain = bm.kernel.__importChain(bundle)
+	}
+
+	log.Errorf("BundleManager: Bundle %s failed: %s", bundle.Name(), failure.Error())
+	if failure.StackTrace != "" {
+		log.Errorf("BundleManager: %s", failure.StackTrace)
+	}
+
+	if bundle.sandbox != nil {
+		bundle.releaseSandbox()
+	}
+	bundle.setBundleFailed(failure)
+	return failure
+}
+
+func (bm *bundleManager) __tryLoadEntrypoint(bundle *bundle) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if e, ok := r.(error); ok {
@@ -72,20 +229,59 @@ func (bm *bundleManager) __tryLoadBundle(path string, info os.FileInfo, transpil
 		}
 	}()
 
-	return bm.__loadBundle(path, info, transpiler)
+	entrypoint := &resolvedScriptPath{bundle.config.Entrypoint, bundle}
+	_, err = bm.kernel.loadScriptModule(bundle.ID(), bundle.Name(), "/", entrypoint, bundle)
+	return err
 }
 
-func (bm *bundleManager) __loadBundle(path string, info os.FileInfo, transpiler *transpiler) (Bundle, error) {
-	bundleFilesystemConfig := BundleFilesystemConfig{
-		NewModuleFilesystem: bm.__newModuleFilesystem,
-		keyManager:          bm.kernel.keyManager,
-		kernelFilesystem:    bm.kernel.filesystem,
-		appInfo:             info,
-		appPath:             path,
-		writableSection:     false, // TODO: add permission to "write to /kernel/data"
+func (bm *bundleManager) __tryCallStopHook(bundle *bundle) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	// The entrypoint module is registered using the bundle's id
+	module := bundle.findModuleById(bundle.ID())
+	if module == nil {
+		return nil
 	}
 
-	filesystem, err := bm.kernel.kernelConfig.NewBundleFilesystem(bundleFilesystemConfig)
+	hook := module.getModuleExports().Get("onStop")
+	if hook == nil || !hook.IsDefined() {
+		return nil
+	}
+
+	var onStop Callable
+	if err := module.export(hook, &onStop); err != nil {
+		return err
+	}
+
+	log.Infof("BundleManager: Calling onStop hook of bundle %s", bundle.Name())
+	_, err = onStop(bundle.Undefined())
+	return err
+}
+
+func (bm *bundleManager) __tryLoadBundle(path string, info os.FileInfo, transpiler *transpiler) (bundle *bundle, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	return bm.__loadBundle(path, info, transpiler)
+}
+
+func (bm *bundleManager) __loadBundle(path string, info os.FileInfo, transpiler *transpiler) (*bundle, error) {
+	filesystem, err := bm.__newBundleFilesystem(path, info)
 	if err == errNoSuchBundle {
 		if info.IsDir() {
 			return nil, filepath.SkipDir
@@ -97,9 +293,77 @@ func (bm *bundleManager) __loadBundle(path string, info os.FileInfo, transpiler
 		return nil, err
 	}
 
+	// Directories without a bundle configuration are no bundles
+	if !fileExists(filesystem, bundleJson) {
+		return nil, nil
+	}
+
 	return bm.__newBundle(path, filesystem, transpiler)
 }
 
+func (bm *bundleManager) __newBundleFilesystem(path string, info os.FileInfo) (afero.Fs, error) {
+	return bm.__createBundleFilesystem(bm.__bundleFilesystemConfig(path, info))
+}
+
+// __createBundleFilesystem creates the filesystem of a bundle and rejects
+// bundles violating the signature policy of the kernel
+func (bm *bundleManager) __createBundleFilesystem(bundleFilesystemConfig BundleFilesystemConfig) (afero.Fs, error) {
+	verifier := newSignatureVerifier(bm.kernel.keyManager)
+	bundleFilesystemConfig.keyManager = verifier
+
+	filesystem, err := bm.kernel.kernelConfig.NewBundleFilesystem(bundleFilesystemConfig)
+	if err == errNoSuchBundle {
+		return nil, err
+	}
+
+	// Paths without a bundle configuration are no bundles at all
+	if err == nil && !fileExists(filesystem, bundleJson) {
+		return filesystem, nil
+	}
+
+	archive := !bundleFilesystemConfig.appInfo.IsDir()
+	policy := bm.kernel.kernelConfig.SignaturePolicy
+	if err := verifier.checkSignature(bundleFilesystemConfig.appPath, archive, policy, err); err != nil {
+		return nil, err
+	}
+	return filesystem, nil
+}
+
+func (bm *bundleManager) __bundleFilesystemConfig(path string, info os.FileInfo) BundleFilesystemConfig {
+	return BundleFilesystemConfig{
+		NewModuleFilesystem: bm.__newModuleFilesystem,
+		kernelFilesystem:    bm.kernel.filesystem,
+		appInfo:             info,
+		appPath:             path,
+		writableSection:     false,
+	}
+}
+
+// __mountDataSection recreates the filesystem of the bundle, which has
+// to be configured already, with its writable data section. The section
+// is stored outside of the bundle's path and therefore kept on updates.
+func (bm *bundleManager) __mountDataSection(bundle *bundle) error {
+	info, err := bm.kernel.filesystem.Stat(bundle.getBasePath())
+	if err != nil {
+		return errors.New(err)
+	}
+
+	bundleFilesystemConfig := bm.__bundleFilesystemConfig(bundle.getBasePath(), info)
+	bundleFilesystemConfig.writableSection = true
+	bundleFilesystemConfig.dataPath = bundleDataSectionPath(bundle.ID())
+	bundleFilesystemConfig.dataQuota = bundle.limits.DataQuota
+
+	filesystem, err := bm.__createBundleFilesystem(bundleFilesystemConfig)
+	if err != nil {
+		return err
+	}
+	if bm.kernel.auditLog != nil {
+		filesystem = newAuditFs(filesystem, bundle, bm.kernel.auditLog)
+	}
+	bundle.filesystem = filesystem
+	return nil
+}
+
 func (bm *bundleManager) __newModuleFilesystem() (afero.Fs, error) {
 	exportfs := newKernelFs()
 	root := exportfs.root
@@ -126,8 +390,13 @@ func __defaultNewBundleFilesystem(bundleFilesystemConfig BundleFilesystemConfig)
 		compositefs = NewCompositeFs(rootfs)
 	}
 
-	if filepath.Ext(path) == "bacc" {
-		rootfs, err := bacc.NewBaccFilesystem(path, bundleFilesystemConfig.keyManager)
+	if !bundleFilesystemConfig.appInfo.IsDir() && filepath.Ext(path) == ".bacc" {
+		// Archives are read by their host filesystem path
+		archivePath, err := realPath(bundleFilesystemConfig.kernelFilesystem, path)
+		if err != nil {
+			return nil, err
+		}
+		rootfs, err := bacc.NewBaccFilesystem(archivePath, bundleFilesystemConfig.keyManager)
 		if err != nil {
 			return nil, err
 		}
@@ -140,8 +409,34 @@ func __defaultNewBundleFilesystem(bundleFilesystemConfig BundleFilesystemConfig)
 			return nil, err
 		}
 		compositefs.Mount(moduleFilesystem, KernelVfsTypesPath)
+
+		if bundleFilesystemConfig.writableSection {
+			datafs, err := __newDataSectionFilesystem(bundleFilesystemConfig)
+			if err != nil {
+				return nil, err
+			}
+			compositefs.Mount(datafs, bundleDataPath)
+		}
 		return compositefs, nil
 	}
 
 	return nil, errNoSuchBundle
 }
+
+func __newDataSectionFilesystem(bundleFilesystemConfig BundleFilesystemConfig) (afero.Fs, error) {
+	kernelFilesystem := bundleFilesystemConfig.kernelFilesystem
+	if err := kernelFilesystem.MkdirAll(bundleFilesystemConfig.dataPath, os.ModePerm); err != nil {
+		return nil, errors.New(err)
+	}
+
+	datafs := afero.NewBasePathFs(kernelFilesystem, bundleFilesystemConfig.dataPath)
+	if bundleFilesystemConfig.dataQuota == 0 {
+		return datafs, nil
+	}
+	return newQuotaFs(datafs, int64(bundleFilesystemConfig.dataQuota))
+}
+
+// bundleDataSectionPath returns the kernel path of the bundle's data section
+func bundleDataSectionPath(id string) string {
+	return filepath.Join(KernelVfsWritablePath, id)
+}
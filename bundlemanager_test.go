@@ -0,0 +1,166 @@
+package gomini_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+	"github.com/spf13/afero"
+)
+
+// lifecycleIndex reports its start and stop through the onStop hook
+const lifecycleIndex = `
+	System.register([], function (exports_1) {
+		return {
+			setters: [],
+			execute: function () {
+				exports_1("onStop", function () {
+					report("stopped");
+				});
+				report("started");
+			}
+		};
+	});
+`
+
+func TestStoppingTheKernelStopsBundles(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{"index.ts": lifecycleIndex})
+	tk.start()
+	tk.expectReports("started")
+
+	if err := tk.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("stopped")
+	tk.expectNoReport(50 * time.Millisecond)
+}
+
+func TestBundleLifecycle(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	path := tk.writeBundle("app", nil, map[string]string{"index.ts": lifecycleIndex})
+	tk.start()
+	tk.expectReports("started")
+
+	if err := tk.StopBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("stopped")
+	tk.awaitStatus("app", gomini.BundleStatusStopped)
+
+	if err := tk.StartBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("started")
+	tk.awaitStatus("app", gomini.BundleStatusStarted)
+
+	if err := tk.RestartBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("stopped", "started")
+
+	if err := tk.UninstallBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("stopped")
+	if tk.Bundle("app") != nil {
+		t.Fatal("bundle is still installed after uninstalling it")
+	}
+	if exists, _ := afero.Exists(tk.filesystem, path); exists {
+		t.Fatal("files of the bundle weren't deleted")
+	}
+	if err := tk.StartBundle("app"); err == nil {
+		t.Fatal("starting an uninstalled bundle succeeded")
+	}
+}
+
+func TestInstallBundle(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.start()
+
+	tk.writeBundle("app", nil, map[string]string{"index.ts": lifecycleIndex})
+	bundle, err := tk.InstallBundle(filepath.Join(gomini.KernelVfsAppsPath, "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Status() != gomini.BundleStatusInstalled {
+		t.Fatalf("installed bundle is %s", bundle.Status())
+	}
+	tk.expectNoReport(50 * time.Millisecond)
+
+	if err := tk.StartBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("started")
+
+	if len(tk.Bundles()) != 1 {
+		t.Fatalf("%d bundles are installed, expected 1", len(tk.Bundles()))
+	}
+}
+
+func TestUnsignedBundlesAreRejected(t *testing.T) {
+	tk := newTestKernel(t, func(config *gomini.KernelConfig) {
+		config.SignaturePolicy = gomini.SignaturePolicy{RequireSigned: true}
+	})
+	tk.start()
+
+	tk.writeBundle("app", nil, map[string]string{"index.ts": lifecycleIndex})
+	if _, err := tk.InstallBundle(filepath.Join(gomini.KernelVfsAppsPath, "app")); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Fatalf("unsigned bundle was installed: %v", err)
+	}
+	if tk.Bundle("app") != nil {
+		t.Fatal("unsigned bundle is installed")
+	}
+}
+
+// writeFileApi lets scripts write files through the bundle's filesystem
+func writeFileApi(config *gomini.KernelConfig) {
+	config.BundleApiProviders = append(config.BundleApiProviders,
+		func(kernel gomini.Bundle, bundle gomini.Bundle, builder gomini.ObjectCreator) {
+			builder.DefineFunction("writeFile", "writeFile", func(call gomini.FunctionCall) gomini.Value {
+				err := afero.WriteFile(bundle.Filesystem(), call.Argument(0).String(), []byte(call.Argument(1).String()), 0644)
+				if err != nil {
+					return bundle.ToValue(err.Error())
+				}
+				return bundle.ToValue("written")
+			})
+		})
+}
+
+func TestDataSection(t *testing.T) {
+	tk := newTestKernel(t, writeFileApi)
+	index := scriptIndex(`
+		report(writeFile("/data/state.txt", "12345678"));
+		report(writeFile("/data/more.txt", "12345678"));
+	`)
+	tk.writeBundle("privileged", map[string]interface{}{
+		"privileges": []string{"PRIVILEGE_DATA"},
+		"limits":     map[string]interface{}{"data_quota": "10"},
+	}, map[string]string{"index.ts": index})
+	tk.writeBundle("unprivileged", nil, map[string]string{"index.ts": index})
+	tk.start()
+
+	reported := map[string][]interface{}{}
+	for i := 0; i < 4; i++ {
+		report := tk.nextReport()
+		reported[report.bundleId] = append(reported[report.bundleId], report.value)
+	}
+	if values := reported["privileged"]; values[0] != "written" || !strings.Contains(values[1].(string), "quota") {
+		t.Fatalf("unexpected writes of the privileged bundle %v", values)
+	}
+	for _, value := range reported["unprivileged"] {
+		if value == "written" {
+			t.Fatal("bundle without the privilege wrote into a data section")
+		}
+	}
+
+	// The data section lives outside of the bundle's path
+	data, err := afero.ReadFile(tk.filesystem, filepath.Join(gomini.KernelVfsWritablePath, "privileged", "state.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "12345678" {
+		t.Fatalf("unexpected content %q", data)
+	}
+}
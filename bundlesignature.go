@@ -0,0 +1,53 @@
+package gomini
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+)
+
+// signatureVerifier is the KeyManager handed to the filesystem of a
+// bundle archive. It only records signers unknown to the kernel's
+// KeyManager to report them, whether the archive's signature is valid is
+// decided by the archive filesystem itself.
+type signatureVerifier struct {
+	keyManager KeyManager
+	unknown    []string
+}
+
+func newSignatureVerifier(keyManager KeyManager) *signatureVerifier {
+	return &signatureVerifier{
+		keyManager: keyManager,
+	}
+}
+
+func (v *signatureVerifier) GetKey(fingerprint string) ([]byte, error) {
+	if v.keyManager == nil {
+		v.unknown = append(v.unknown, fingerprint)
+		return nil, errors.New("no KeyManager configured to verify bundle signatures")
+	}
+
+	key, err := v.keyManager.GetKey(fingerprint)
+	if err != nil {
+		v.unknown = append(v.unknown, fingerprint)
+		return nil, errors.New(fmt.Sprintf("unknown signer %s: %s", fingerprint, err.Error()))
+	}
+	return key, nil
+}
+
+// checkSignature applies the signature policy to the bundle found at the
+// given path, after its filesystem was created using the verifier. The
+// filesystem of an archive verifies the archive's signature and fails to
+// open archives which are unsigned or whose signature doesn't match, so
+// only archives opened without an error count as signed.
+func (v *signatureVerifier) checkSignature(path string, archive bool, policy SignaturePolicy, err error) error {
+	if err != nil {
+		if len(v.unknown) > 0 {
+			return errors.New(fmt.Sprintf("signature of bundle kernel:/%s cannot be verified, unknown signer %s", path, v.unknown[0]))
+		}
+		return err
+	}
+	if !archive && policy.RequireSigned {
+		return errors.New(fmt.Sprintf("bundle kernel:/%s is not signed", path))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package gomini
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type staticKeyManager map[string][]byte
+
+func (m staticKeyManager) GetKey(fingerprint string) ([]byte, error) {
+	if key, ok := m[fingerprint]; ok {
+		return key, nil
+	}
+	return nil, errors.New("no such key")
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	keys := staticKeyManager{"known": []byte("key")}
+
+	// Directory bundles are only rejected if signatures are required
+	directory := newSignatureVerifier(keys)
+	if err := directory.checkSignature("/app", false, SignaturePolicy{}, nil); err != nil {
+		t.Fatalf("directory bundle was rejected: %s", err)
+	}
+	if err := directory.checkSignature("/app", false, SignaturePolicy{RequireSigned: true}, nil); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Fatalf("unsigned bundle was accepted: %v", err)
+	}
+
+	// Archives count as signed if the archive verified its signature
+	signed := newSignatureVerifier(keys)
+	if _, err := signed.GetKey("known"); err != nil {
+		t.Fatal(err)
+	}
+	if err := signed.checkSignature("/app.bacc", true, SignaturePolicy{RequireSigned: true}, nil); err != nil {
+		t.Fatalf("signed bundle was rejected: %s", err)
+	}
+	archiveErr := errors.New("signature verification failed")
+	if err := signed.checkSignature("/app.bacc", true, SignaturePolicy{}, archiveErr); err != archiveErr {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Failures caused by unknown signers name the signer
+	unknown := newSignatureVerifier(keys)
+	if _, err := unknown.GetKey("unknown"); err == nil {
+		t.Fatal("unknown key was returned")
+	}
+	if err := unknown.checkSignature("/app.bacc", true, SignaturePolicy{}, archiveErr); err == nil || !strings.Contains(err.Error(), "unknown signer unknown") {
+		t.Fatalf("bundle of an unknown signer was accepted: %v", err)
+	}
+
+	withoutKeyManager := newSignatureVerifier(nil)
+	if _, err := withoutKeyManager.GetKey("known"); err == nil {
+		t.Fatal("key was returned without a KeyManager")
+	}
+}
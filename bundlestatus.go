@@ -0,0 +1,92 @@
+package gomini
+
+import (
+	"sync"
+	"github.com/apex/log"
+)
+
+type statusListenerRegistration struct {
+	id       uint64
+	owner    string
+	listener BundleStatusListener
+}
+
+type bundleStatusListeners struct {
+	mutex         sync.Mutex
+	nextId        uint64
+	registrations []*statusListenerRegistration
+}
+
+func newBundleStatusListeners() *bundleStatusListeners {
+	return &bundleStatusListeners{
+		registrations: make([]*statusListenerRegistration, 0),
+	}
+}
+
+// add registers the listener on behalf of the owner bundle id. Listeners
+// registered by the embedding Go code use an empty owner.
+func (l *bundleStatusListeners) add(owner string, listener BundleStatusListener) func() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.nextId++
+	registration := &statusListenerRegistration{
+		id:       l.nextId,
+		owner:    owner,
+		listener: listener,
+	}
+	l.registrations = append(l.registrations, registration)
+
+	return func() {
+		l.remove(registration.id)
+	}
+}
+
+func (l *bundleStatusListeners) remove(id uint64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i, registration := range l.registrations {
+		if registration.id == id {
+			l.registrations = append(l.registrations[:i], l.registrations[i+1:]...)
+			break
+		}
+	}
+}
+
+// removeOwner removes all listeners registered by the given bundle,
+// used when the bundle's sandbox is released.
+func (l *bundleStatusListeners) removeOwner(owner string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	registrations := make([]*statusListenerRegistration, 0, len(l.registrations))
+	for _, registration := range l.registrations {
+		if registration.owner != owner {
+			registrations = append(registrations, registration)
+		}
+	}
+	l.registrations = registrations
+}
+
+func (l *bundleStatusListeners) fire(event BundleStatusEvent) {
+	// Copy the listeners to allow (un-)registration from inside a listener
+	l.mutex.Lock()
+	registrations := make([]*statusListenerRegistration, len(l.registrations))
+	copy(registrations, l.registrations)
+	l.mutex.Unlock()
+
+	for _, registration := range registrations {
+		l.__tryNotify(registration, event)
+	}
+}
+
+func (l *bundleStatusListeners) __tryNotify(registration *statusListenerRegistration, event BundleStatusEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("Kernel: Bundle status listener failed: %v", r)
+		}
+	}()
+
+	registration.listener(event)
+}
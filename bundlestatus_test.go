@@ -0,0 +1,144 @@
+package gomini_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+)
+
+// crashingIndex throws an uncaught error from a timer after starting
+const crashingIndex = `
+	System.register([], function (exports_1) {
+		return {
+			setters: [],
+			execute: function () {
+				report("started");
+				setTimeout(function () {
+					throw new Error("crashed on purpose");
+				}, 1);
+			}
+		};
+	});
+`
+
+// statusRecorder collects the status transitions of a single bundle
+type statusRecorder struct {
+	events chan gomini.BundleStatusEvent
+}
+
+func recordStatus(tk *testKernel, id string) *statusRecorder {
+	recorder := &statusRecorder{events: make(chan gomini.BundleStatusEvent, 100)}
+	remove := tk.AddBundleStatusListener(func(event gomini.BundleStatusEvent) {
+		if event.BundleID == id {
+			recorder.events <- event
+		}
+	})
+	tk.t.Cleanup(remove)
+	return recorder
+}
+
+func (r *statusRecorder) expect(t *testing.T, statuses ...gomini.BundleStatus) {
+	for _, expected := range statuses {
+		select {
+		case event := <-r.events:
+			if event.NewStatus != expected {
+				t.Fatalf("bundle %s changed to %s, expected %s", event.BundleID, event.NewStatus, expected)
+			}
+		case <-time.After(reportTimeout):
+			t.Fatalf("bundle didn't change to %s within %s", expected, reportTimeout)
+		}
+	}
+}
+
+func TestStatusEvents(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{"index.ts": lifecycleIndex})
+	recorder := recordStatus(tk, "app")
+	tk.start()
+	tk.expectReports("started")
+	recorder.expect(t, gomini.BundleStatusInstalled, gomini.BundleStatusStarting, gomini.BundleStatusStarted)
+
+	if err := tk.RestartBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	recorder.expect(t,
+		gomini.BundleStatusStopping, gomini.BundleStatusStopped,
+		gomini.BundleStatusStarting, gomini.BundleStatusStarted,
+	)
+
+	// Removed listeners aren't notified anymore
+	events := make(chan gomini.BundleStatusEvent, 10)
+	remove := tk.AddBundleStatusListener(func(event gomini.BundleStatusEvent) {
+		events <- event
+	})
+	remove()
+	if err := tk.StopBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	recorder.expect(t, gomini.BundleStatusStopping, gomini.BundleStatusStopped)
+	if len(events) != 0 {
+		t.Fatal("removed listener was notified")
+	}
+}
+func TestFailedBundleKeepsFailure(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("broken", nil, map[string]string{
+		"index.ts": `
+			System.register(["./helper"], function (exports_1) {
+				return {
+					setters: [function () {}],
+					execute: function () {}
+				};
+			});
+		`,
+		"helper.ts": `
+			System.register([], function (exports_1) {
+				return {
+					setters: [],
+					execute: function () {
+						throw new Error("failed on purpose");
+					}
+				};
+			});
+		`,
+	})
+	tk.writeBundle("crashing", nil, map[string]string{"index.ts": crashingIndex})
+	tk.start()
+	tk.expectReports("started")
+
+	broken := tk.awaitStatus("broken", gomini.BundleStatusFailed)
+	failure := broken.Failure()
+	if failure == nil || !strings.Contains(failure.Error(), "failed on purpose") {
+		t.Fatalf("unexpected failure %v", failure)
+	}
+	if len(failure.ImportChain) == 0 || !strings.Contains(failure.ImportChain[len(failure.ImportChain)-1], "helper") {
+		t.Fatalf("import chain %v doesn't end with the failing module", failure.ImportChain)
+	}
+
+	crashed := tk.awaitStatus("crashing", gomini.BundleStatusFailed)
+	if crashed.Failure() == nil || !strings.Contains(crashed.Failure().Error(), "crashed on purpose") {
+		t.Fatalf("unexpected failure %v", crashed.Failure())
+	}
+
+	// Failures are cleared by starting the bundle again
+	if err := tk.StartBundle("crashing"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("started")
+	if tk.bundle("crashing").Failure() != nil {
+		t.Fatal("started bundle still reports its previous failure")
+	}
+}
+
+func TestInvalidBundleConfigFails(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", map[string]interface{}{"privileges": "PRIVILEGE_ALL"}, map[string]string{"index.ts": lifecycleIndex})
+	tk.start()
+
+	bundle := tk.awaitStatus("app", gomini.BundleStatusFailed)
+	if bundle.Failure() == nil || !strings.Contains(bundle.Failure().Error(), "privileges") {
+		t.Fatalf("unexpected failure %v", bundle.Failure())
+	}
+	tk.expectNoReport(50 * time.Millisecond)
+}
@@ -0,0 +1,477 @@
+package gomini
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"github.com/go-errors/errors"
+	"github.com/apex/log"
+	"github.com/spf13/afero"
+)
+
+const (
+	defaultHealthCheckTimeout = 30 * time.Second
+	healthCheckInterval       = 500 * time.Millisecond
+
+	// kernelVfsSlotsPath keeps the slot markers of updated bundles
+	kernelVfsSlotsPath = "/kernel/slots"
+)
+
+// slotMarker records the path of the active version of an updated bundle
+// and the path of the version it replaced, which is removed after the
+// update. Paths of the bundle other than the active one are leftovers
+// of an update interrupted by a crash.
+type slotMarker struct {
+	Active   string `json:"active"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// bundleUpdate is an update in progress, while the health check of the
+// candidate runs without holding the bundle manager's lock
+type bundleUpdate struct {
+	installed      *bundle
+	candidate      *bundle
+	slot           string
+	dependents     []*bundle
+	running        bool
+	failures       chan error
+	removeListener func()
+}
+
+// updateBundle performs an A/B update of the installed bundle. The new
+// version is copied into the unused slot next to the installed bundle,
+// the installed version's files are only removed after the new version
+// was started successfully and passed its health check. The health check
+// runs without holding the bundle manager's lock, so failures of the new
+// version are handled meanwhile and roll the update back. The active
+// version is recorded in a slot marker, versions left behind by a crash
+// during an update are removed at the next boot.
+func (bm *bundleManager) updateBundle(id, path string, options BundleUpdateOptions) error {
+	var update *bundleUpdate
+	err := bm.withBundle(id, func(installed *bundle) error {
+		var err error
+		update, err = bm.__beginUpdate(installed, path, options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer bm.__endUpdate(update)
+
+	var healthErr error
+	if update.running {
+		healthErr = bm.__awaitHealthy(update.candidate, options, update.failures)
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	return bm.__completeUpdate(update, healthErr)
+}
+
+func (bm *bundleManager) __beginUpdate(installed *bundle, path string, options BundleUpdateOptions) (*bundleUpdate, error) {
+	path = filepath.Clean(path)
+	if strings.HasPrefix(path+"/", KernelVfsAppsPath+"/") {
+		return nil, errors.New(fmt.Sprintf("update of bundle %s must not be located in %s", installed.Name(), KernelVfsAppsPath))
+	}
+	if bm.updating[installed.ID()] {
+		return nil, errors.New(fmt.Sprintf("bundle %s is already being updated", installed.Name()))
+	}
+
+	// The installed version stays active until the update is completed
+	if err := bm.__writeSlotMarker(installed.ID(), slotMarker{Active: installed.getBasePath()}); err != nil {
+		return nil, err
+	}
+
+	slot := bm.__updateSlot(installed, path)
+	candidate, err := bm.__stageUpdate(installed, path, slot)
+	if err != nil {
+		bm.kernel.filesystem.RemoveAll(slot)
+		return nil, err
+	}
+
+	log.Infof("BundleManager: Updating bundle %s from %s to %s", installed.Name(),
+		installed.Version(), describeVersionChange(installed.Version(), candidate.Version()))
+
+	update := &bundleUpdate{
+		installed:  installed,
+		candidate:  candidate,
+		slot:       slot,
+		running:    installed.Status() == BundleStatusStarted,
+		dependents: bm.__startedDependents(installed),
+		failures:   make(chan error, 1),
+	}
+
+	if update.running {
+		installed.setBundleStatus(BundleStatusUpdating)
+	}
+	if err := bm.stopBundle(installed); err != nil {
+		candidate.releaseIoPool()
+		bm.kernel.filesystem.RemoveAll(slot)
+		return nil, err
+	}
+	bm.replaceBundle(installed, candidate)
+	bm.updating[installed.ID()] = true
+
+	// Failures of the candidate during the health check abort the check
+	update.removeListener = bm.kernel.statusListeners.add("", func(event BundleStatusEvent) {
+		if event.Bundle != Bundle(candidate) {
+			return
+		}
+		if event.NewStatus == BundleStatusFailed || event.NewStatus == BundleStatusStopped {
+			err := event.Cause
+			if err == nil {
+				err = errors.New(fmt.Sprintf("bundle %s changed to %s", candidate.Name(), event.NewStatus))
+			}
+			update.fail(err)
+		}
+	})
+
+	if update.running {
+		if err := bm.__activateUpdate(candidate, update.dependents); err != nil {
+			update.fail(err)
+		}
+	}
+	return update, nil
+}
+
+// fail records the first failure of the update
+func (u *bundleUpdate) fail(err error) {
+	select {
+	case u.failures <- err:
+	default:
+	}
+}
+
+// __completeUpdate removes the previous version after a successful health
+// check or rolls the update back. It must be called holding the lock.
+func (bm *bundleManager) __completeUpdate(update *bundleUpdate, healthErr error) error {
+	installed, candidate := update.installed, update.candidate
+
+	if bm.findBundleById(installed.ID()) != candidate {
+		// The candidate was uninstalled during the health check
+		installed.releaseIoPool()
+		bm.kernel.filesystem.RemoveAll(installed.getBasePath())
+		return errors.New(fmt.Sprintf("bundle %s was uninstalled during its update", installed.Name()))
+	}
+
+	// The previous version must not be removed before the new one is
+	// recorded as active, otherwise a crash would leave neither of them
+	if healthErr == nil {
+		healthErr = bm.__writeSlotMarker(installed.ID(), slotMarker{
+			Active:   update.slot,
+			Previous: installed.getBasePath(),
+		})
+	}
+
+	if healthErr != nil {
+		bm.__rollbackUpdate(installed, candidate, update.dependents)
+		candidate.releaseIoPool()
+		bm.kernel.filesystem.RemoveAll(update.slot)
+		return errors.New(fmt.Sprintf("update of bundle %s to version %s was rolled back: %s",
+			installed.Name(), candidate.Version(), healthErr.Error()))
+	}
+
+	installed.releaseIoPool()
+	if err := bm.kernel.filesystem.RemoveAll(installed.getBasePath()); err != nil {
+		log.Warnf("BundleManager: Removing previous version of bundle %s failed: %s", installed.Name(), err.Error())
+	}
+
+	log.Infof("BundleManager: Updated bundle %s to version %s", candidate.Name(), candidate.Version())
+	return nil
+}
+
+func (bm *bundleManager) __endUpdate(update *bundleUpdate) {
+	update.removeListener()
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	delete(bm.updating, update.installed.ID())
+}
+
+// __updateSlot returns the path the new version is staged at. Bundles
+// alternate between the slots "<id>.a" and "<id>.b" inside the apps path.
+func (bm *bundleManager) __updateSlot(installed *bundle, path string) string {
+	extension := ""
+	if filepath.Ext(path) == ".bacc" {
+		extension = ".bacc"
+	}
+
+	slot := filepath.Join(KernelVfsAppsPath, installed.ID()+".a"+extension)
+	if slot == filepath.Clean(installed.getBasePath()) {
+		slot = filepath.Join(KernelVfsAppsPath, installed.ID()+".b"+extension)
+	}
+	return slot
+}
+
+// __writeSlotMarker replaces the slot marker of the bundle atomically, after
+// a crash the marker names either the previous or the new active version
+func (bm *bundleManager) __writeSlotMarker(id string, marker slotMarker) error {
+	filesystem := bm.kernel.filesystem
+	if err := filesystem.MkdirAll(kernelVfsSlotsPath, 0755); err != nil {
+		return errors.New(err)
+	}
+
+	marker.Active = filepath.Clean(marker.Active)
+	if marker.Previous != "" {
+		marker.Previous = filepath.Clean(marker.Previous)
+	}
+	content, err := json.Marshal(marker)
+	if err != nil {
+		return errors.New(err)
+	}
+
+	filename := filepath.Join(kernelVfsSlotsPath, id)
+	if err := writeSynced(filesystem, filename+".tmp", content); err != nil {
+		return errors.New(err)
+	}
+	if err := filesystem.Rename(filename+".tmp", filename); err != nil {
+		return errors.New(err)
+	}
+	return nil
+}
+
+func (bm *bundleManager) __removeSlotMarker(id string) error {
+	err := bm.kernel.filesystem.Remove(filepath.Join(kernelVfsSlotsPath, id))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New(err)
+	}
+	return nil
+}
+
+// __reconcileSlots removes the versions of updated bundles left behind by
+// updates interrupted by a crash, before the bundles are loaded at boot.
+// Markers whose active version doesn't exist anymore are dropped without
+// touching any bundle.
+func (bm *bundleManager) __reconcileSlots() {
+	filesystem := bm.kernel.filesystem
+	infos, err := afero.ReadDir(filesystem, kernelVfsSlotsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("BundleManager: Reading the update slots failed: %s", err.Error())
+		}
+		return
+	}
+
+	for _, info := range infos {
+		filename := filepath.Join(kernelVfsSlotsPath, info.Name())
+		if filepath.Ext(filename) == ".tmp" {
+			filesystem.Remove(filename)
+			continue
+		}
+		if err := bm.__reconcileSlot(info.Name(), filename); err != nil {
+			log.Warnf("BundleManager: Cleaning up the update slots of bundle %s failed: %s", info.Name(), err.Error())
+		}
+	}
+}
+
+func (bm *bundleManager) __reconcileSlot(id, filename string) error {
+	filesystem := bm.kernel.filesystem
+	content, err := afero.ReadFile(filesystem, filename)
+	if err != nil {
+		return err
+	}
+
+	marker := slotMarker{}
+	if err := json.Unmarshal(content, &marker); err != nil || marker.Active == "" || !fileExists(filesystem, marker.Active) {
+		return filesystem.Remove(filename)
+	}
+
+	stale := []string{marker.Previous}
+	for _, slot := range []string{".a", ".b"} {
+		for _, extension := range []string{"", ".bacc"} {
+			stale = append(stale, filepath.Join(KernelVfsAppsPath, id+slot+extension))
+		}
+	}
+	for _, path := range stale {
+		if path == "" || path == marker.Active || !fileExists(filesystem, path) {
+			continue
+		}
+		log.Warnf("BundleManager: Removing kernel:/%s left behind by an interrupted update of bundle %s", path, id)
+		if err := filesystem.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bm *bundleManager) __stageUpdate(installed *bundle, path, slot string) (*bundle, error) {
+	filesystem := bm.kernel.filesystem
+
+	// Leftovers of an interrupted update
+	if err := filesystem.RemoveAll(slot); err != nil {
+		return nil, errors.New(err)
+	}
+	if err := copyPath(filesystem, path, slot); err != nil {
+		return nil, errors.New(err)
+	}
+
+	candidate, err := bm.__tryNewUpdateCandidate(installed, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bm.kernel.__invalidateScriptCache(candidate); err != nil {
+		candidate.releaseIoPool()
+		return nil, errors.New(err)
+	}
+	return candidate, nil
+}
+
+func (bm *bundleManager) __tryNewUpdateCandidate(installed *bundle, slot string) (candidate *bundle, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	return bm.__newUpdateCandidate(installed, slot)
+}
+
+// __newUpdateCandidate loads the staged bundle without registering it,
+// the candidate only replaces the installed bundle after being validated.
+func (bm *bundleManager) __newUpdateCandidate(installed *bundle, slot string) (*bundle, error) {
+	info, err := bm.kernel.filesystem.Stat(slot)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	filesystem, err := bm.__newBundleFilesystem(slot, info)
+	if err != nil {
+		return nil, err
+	}
+	if !fileExists(filesystem, bundleJson) {
+		return nil, errNoSuchBundle
+	}
+
+	config, err := bm.__readBundleConfig(filesystem)
+	if err != nil {
+		return nil, err
+	}
+	if config.Id != installed.ID() {
+		return nil, errors.New(fmt.Sprintf("update has bundle id %s, but %s is expected", config.Id, installed.ID()))
+	}
+
+	name := config.Name
+	if name == "" {
+		name = config.Id
+	}
+
+	candidate, err := newBundle(bm.kernel, slot, filesystem, config.Id, name, config.Privileges)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bm.__configureBundle(candidate, config); err != nil {
+		return nil, err
+	}
+	return candidate, nil
+}
+
+func (bm *bundleManager) __activateUpdate(candidate *bundle, dependents []*bundle) error {
+	if err := bm.startBundle(candidate); err != nil {
+		return err
+	}
+
+	for _, dependent := range dependents {
+		if err := bm.startBundle(dependent); err != nil {
+			return errors.New(fmt.Sprintf("dependent bundle %s failed: %s", dependent.Name(), err.Error()))
+		}
+	}
+	return nil
+}
+
+func (bm *bundleManager) __rollbackUpdate(installed, candidate *bundle, dependents []*bundle) {
+	log.Warnf("BundleManager: Rolling back bundle %s to version %s", installed.Name(), installed.Version())
+
+	// Stopping the candidate stops the started dependents as well
+	if err := bm.stopBundle(candidate); err != nil {
+		log.Warnf("BundleManager: Stopping bundle %s failed: %s", candidate.Name(), err.Error())
+	}
+	bm.replaceBundle(candidate, installed)
+
+	if err := bm.startBundle(installed); err != nil {
+		log.Errorf("BundleManager: Restarting previous version of bundle %s failed: %s", installed.Name(), err.Error())
+		return
+	}
+
+	for _, dependent := range dependents {
+		if err := bm.startBundle(dependent); err != nil {
+			log.Warnf("BundleManager: Restarting dependent bundle %s failed: %s", dependent.Name(), err.Error())
+		}
+	}
+}
+
+// __awaitHealthy runs the health check until it passes, the bundle stops
+// running or the health check timeout elapsed. Failures of the bundle are
+// received from the status transitions, as the status must not be read
+// without holding the bundle manager's lock.
+func (bm *bundleManager) __awaitHealthy(candidate *bundle, options BundleUpdateOptions, failures <-chan error) error {
+	select {
+	case err := <-failures:
+		return err
+	default:
+	}
+	if options.HealthCheck == nil {
+		return nil
+	}
+
+	timeout := options.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		result := make(chan error, 1)
+		go func() {
+			result <- bm.__tryHealthCheck(candidate, options.HealthCheck)
+		}()
+
+		var err error
+		select {
+		case err = <-result:
+		case err = <-failures:
+			return err
+		case <-time.After(time.Until(deadline)):
+			return errors.New(fmt.Sprintf("health check of bundle %s timed out after %s", candidate.Name(), timeout))
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errors.New(fmt.Sprintf("health check of bundle %s failed: %s", candidate.Name(), err.Error()))
+		}
+		if remaining > healthCheckInterval {
+			remaining = healthCheckInterval
+		}
+		select {
+		case err := <-failures:
+			return err
+		case <-time.After(remaining):
+		}
+	}
+}
+
+func (bm *bundleManager) __tryHealthCheck(candidate *bundle, healthCheck BundleHealthCheck) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	return healthCheck(candidate)
+}
@@ -0,0 +1,170 @@
+package gomini_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+	"github.com/spf13/afero"
+)
+
+const updatePath = "/updates/app"
+
+func writeUpdate(tk *testKernel, index string) {
+	writeBundle(tk.t, tk.filesystem, updatePath, "app", map[string]interface{}{"version": "2.0.0"},
+		map[string]string{"index.ts": index})
+}
+
+func expectVersion(t *testing.T, bundle gomini.Bundle, expected string) {
+	if version := bundle.Version().String(); version != expected {
+		t.Fatalf("bundle %s is installed in version %s, expected %s", bundle.ID(), version, expected)
+	}
+}
+
+func TestUpdateBundle(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{"index.ts": reportingIndex("v1")})
+	tk.start()
+	tk.expectReports("v1")
+
+	writeUpdate(tk, reportingIndex("v2"))
+	healthChecks := 0
+	err := tk.UpdateBundle("app", updatePath, gomini.BundleUpdateOptions{
+		HealthCheck: func(bundle gomini.Bundle) error {
+			healthChecks++
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("v2")
+	if healthChecks == 0 {
+		t.Fatal("health check wasn't called")
+	}
+
+	bundle := tk.awaitStatus("app", gomini.BundleStatusStarted)
+	expectVersion(t, bundle, "2.0.0")
+
+	// Updated bundles keep working after restarts
+	if err := tk.RestartBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("v2")
+}
+
+func TestInterruptedUpdatesAreCleanedUpAtBoot(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	installed := filepath.Join(gomini.KernelVfsAppsPath, "installed")
+	writeBundle(t, tk.filesystem, installed, "app", nil, map[string]string{"index.ts": reportingIndex("v1")})
+	tk.start()
+	tk.expectReports("v1")
+
+	writeUpdate(tk, reportingIndex("v2"))
+	if err := tk.UpdateBundle("app", updatePath, gomini.BundleUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("v2")
+	tk.Stop()
+
+	// A crash before the previous version was removed and another one
+	// while staging the next update leave both versions behind
+	previous := writeBundle(t, tk.filesystem, installed, "app", nil, map[string]string{"index.ts": reportingIndex("v1")})
+	staged := writeBundle(t, tk.filesystem, filepath.Join(gomini.KernelVfsAppsPath, "app.b"), "app",
+		map[string]interface{}{"version": "3.0.0"}, map[string]string{"index.ts": reportingIndex("v3")})
+
+	rebooted := newTestKernel(t, func(config *gomini.KernelConfig) {
+		config.NewKernelFilesystem = func(baseFilesystem afero.Fs) (afero.Fs, error) {
+			return tk.filesystem, nil
+		}
+	})
+	rebooted.start()
+	rebooted.expectReports("v2")
+	rebooted.expectNoReport(50 * time.Millisecond)
+	expectVersion(t, rebooted.bundle("app"), "2.0.0")
+
+	for _, path := range []string{previous, staged} {
+		if exists, _ := afero.Exists(tk.filesystem, path); exists {
+			t.Fatalf("%s was not removed", path)
+		}
+	}
+}
+
+func TestUpdateRollsBackOnFailedHealthCheck(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{"index.ts": reportingIndex("v1")})
+	tk.start()
+	tk.expectReports("v1")
+
+	writeUpdate(tk, reportingIndex("v2"))
+	err := tk.UpdateBundle("app", updatePath, gomini.BundleUpdateOptions{
+		HealthCheck: func(bundle gomini.Bundle) error {
+			return errors.New("not ready")
+		},
+		HealthCheckTimeout: 200 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("unexpected result of the update: %v", err)
+	}
+	tk.expectReports("v2", "v1")
+
+	bundle := tk.awaitStatus("app", gomini.BundleStatusStarted)
+	expectVersion(t, bundle, "1.0.0")
+}
+
+func TestUpdateRollsBackOnCrash(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{"index.ts": reportingIndex("v1")})
+	tk.start()
+	tk.expectReports("v1")
+
+	writeUpdate(tk, crashingIndex)
+	start := time.Now()
+	err := tk.UpdateBundle("app", updatePath, gomini.BundleUpdateOptions{
+		HealthCheck: func(bundle gomini.Bundle) error {
+			return errors.New("not ready")
+		},
+		HealthCheckTimeout: 10 * time.Second,
+	})
+	if err == nil || !strings.Contains(err.Error(), "crashed on purpose") {
+		t.Fatalf("unexpected result of the update: %v", err)
+	}
+	// The crash is noticed without waiting for the health check timeout
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("rollback took %s", elapsed)
+	}
+	tk.expectReports("started", "v1")
+	expectVersion(t, tk.awaitStatus("app", gomini.BundleStatusStarted), "1.0.0")
+}
+
+func TestUpdateRollsBackOnFailingEntrypoint(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{"index.ts": reportingIndex("v1")})
+	tk.start()
+	tk.expectReports("v1")
+
+	writeUpdate(tk, `throw new Error("broken update");`)
+	if err := tk.UpdateBundle("app", updatePath, gomini.BundleUpdateOptions{}); err == nil {
+		t.Fatal("update with a failing entrypoint succeeded")
+	}
+	tk.expectReports("v1")
+	expectVersion(t, tk.awaitStatus("app", gomini.BundleStatusStarted), "1.0.0")
+}
+
+func TestUpdateMustNotBeLocatedInTheAppsPath(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{"index.ts": reportingIndex("v1")})
+	tk.start()
+	tk.expectReports("v1")
+
+	path := writeBundle(t, tk.filesystem, filepath.Join(gomini.KernelVfsAppsPath, "app-v2"), "app",
+		map[string]interface{}{"version": "2.0.0"}, map[string]string{"index.ts": reportingIndex("v2")})
+
+	if err := tk.UpdateBundle("app", path, gomini.BundleUpdateOptions{}); err == nil {
+		t.Fatal("update from the apps path succeeded")
+	}
+	tk.expectNoReport(50 * time.Millisecond)
+	expectVersion(t, tk.bundle("app"), "1.0.0")
+}
@@ -0,0 +1,386 @@
+package gomini
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"github.com/go-errors/errors"
+	"github.com/apex/log"
+)
+
+const minimumTimerDelay = time.Millisecond
+
+var errEventLoopStopped = errors.New("the event loop of the bundle is stopped")
+
+// callMutex guards the call chains holding and waiting for event loops,
+// which are inspected across all bundles to detect deadlocks
+var callMutex sync.Mutex
+
+// callChain is a job and all synchronous calls into other bundles made
+// by it. The chain holds the event loops running its jobs. A loop held by
+// a chain only waits for the chain, so calls of the chain back into the
+// loop are run right away on the calling goroutine.
+type callChain struct {
+	waitingFor *eventLoop
+}
+
+type eventLoopJob struct {
+	run     func()
+	chain   *callChain
+	timeout time.Duration
+}
+
+type eventLoopTimer struct {
+	id       int64
+	delay    time.Duration
+	repeat   bool
+	timer    *time.Timer
+	callback func()
+}
+
+// eventLoop serializes all script executions of a bundle. Jobs are run
+// one after another on a goroutine, which is only alive while jobs are
+// queued. Timers queue their callbacks as jobs when they fire. Promise
+// reactions are run by the sandbox when a job leaves the script runtime,
+// therefore all microtasks of a job run before the next job starts.
+// Jobs exceeding the BundleLimits of the bundle are interrupted by its
+// execution budget.
+//
+// Script runtimes must only be used by their own event loop, therefore
+// calls into other bundles are run as jobs of the other bundle's loop as
+// part of the caller's call chain, see CallBundle.
+type eventLoop struct {
+	bundle  *bundle
+	budget  *executionBudget
+	mutex   sync.Mutex
+	idle    *sync.Cond
+	queue   []eventLoopJob
+	timers  map[int64]*eventLoopTimer
+	nextId  int64
+	running bool
+	stopped bool
+	done    chan struct{}
+	chain   *callChain
+}
+
+func newEventLoop(bundle *bundle) *eventLoop {
+	eventLoop := &eventLoop{
+		bundle: bundle,
+		budget: newExecutionBudget(bundle),
+		queue:  make([]eventLoopJob, 0),
+		timers: make(map[int64]*eventLoopTimer),
+		done:   make(chan struct{}),
+	}
+	eventLoop.idle = sync.NewCond(&eventLoop.mutex)
+	return eventLoop
+}
+
+// submit queues the job and returns false if the event loop is stopped
+func (l *eventLoop) submit(job func()) bool {
+	return l.__submit(eventLoopJob{run: job})
+}
+
+func (l *eventLoop) __submit(job eventLoopJob) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stopped {
+		return false
+	}
+
+	l.queue = append(l.queue, job)
+	if !l.running {
+		l.running = true
+		go l.__run()
+	}
+	return true
+}
+
+// execute queues the job and waits for it to be finished. It must not
+// be called from inside of a job, as the job would wait for itself.
+func (l *eventLoop) execute(job func() error) error {
+	return l.__wait(eventLoopJob{}, job)
+}
+
+// executeWithin executes the job like execute, but additionally limits
+// its execution time to the given timeout
+func (l *eventLoop) executeWithin(timeout time.Duration, job func() error) error {
+	return l.__wait(eventLoopJob{timeout: timeout}, job)
+}
+
+// call runs the job as part of the given call chain and waits for it.
+// If the chain holds the event loop already, the loop is waiting for the
+// chain and the job is run right away. Calls which would wait for a chain
+// waiting for the caller are rejected instead of blocking both forever.
+func (l *eventLoop) call(chain *callChain, job func() error) error {
+	if chain == nil {
+		return l.execute(job)
+	}
+
+	callMutex.Lock()
+	if l.chain == chain {
+		callMutex.Unlock()
+		return l.__tryExecute(job)
+	}
+	if l.__waitsFor(chain) {
+		callMutex.Unlock()
+		return errors.New(fmt.Sprintf("calling into bundle %s would deadlock, it is waiting for the caller", l.bundle.Name()))
+	}
+	previous := chain.waitingFor
+	chain.waitingFor = l
+	callMutex.Unlock()
+
+	defer func() {
+		callMutex.Lock()
+		chain.waitingFor = previous
+		callMutex.Unlock()
+	}()
+	return l.__wait(eventLoopJob{chain: chain}, job)
+}
+
+// currentChain returns the call chain of the job currently running, or
+// nil if the event loop is idle
+func (l *eventLoop) currentChain() *callChain {
+	callMutex.Lock()
+	defer callMutex.Unlock()
+	return l.chain
+}
+
+// __waitsFor follows the chain holding the event loop through the loops
+// it waits for and reports if it ends up waiting for the given chain. It
+// must be called with the callMutex held.
+func (l *eventLoop) __waitsFor(chain *callChain) bool {
+	visited := make(map[*eventLoop]bool)
+	for loop := l; loop != nil && !visited[loop]; {
+		visited[loop] = true
+		holder := loop.chain
+		if holder == nil {
+			return false
+		}
+		if holder == chain {
+			return true
+		}
+		loop = holder.waitingFor
+	}
+	return false
+}
+
+func (l *eventLoop) __hold(chain *callChain) {
+	callMutex.Lock()
+	l.chain = chain
+	callMutex.Unlock()
+}
+
+// __wait queues the job with the chain and timeout of the given job
+// template and waits for its result
+func (l *eventLoop) __wait(template eventLoopJob, job func() error) error {
+	result := make(chan error, 1)
+	template.run = func() {
+		result <- l.__tryExecute(job)
+	}
+	submitted := l.__submit(template)
+	if !submitted {
+		return errEventLoopStopped
+	}
+
+	// Queued jobs are dropped when the event loop is stopped
+	select {
+	case err := <-result:
+		return err
+	case <-l.done:
+		select {
+		case err := <-result:
+			return err
+		default:
+			return errEventLoopStopped
+		}
+	}
+}
+
+// stop cancels all pending timers and jobs and waits for the currently
+// executing job to finish. Like execute, stop must not be called from
+// inside of a job.
+func (l *eventLoop) stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stopped {
+		return
+	}
+
+	l.stopped = true
+	l.queue = nil
+	for id, timer := range l.timers {
+		if timer.timer != nil {
+			timer.timer.Stop()
+		}
+		delete(l.timers, id)
+	}
+
+	for l.running {
+		l.idle.Wait()
+	}
+	close(l.done)
+}
+
+// setTimer schedules the callback after the given delay and, if repeat is
+// set, every delay after that. The returned id is used to clear the timer.
+func (l *eventLoop) setTimer(delay time.Duration, repeat bool, callback func()) int64 {
+	if delay < minimumTimerDelay {
+		delay = minimumTimerDelay
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.nextId++
+	timer := &eventLoopTimer{
+		id:       l.nextId,
+		delay:    delay,
+		repeat:   repeat,
+		callback: callback,
+	}
+
+	if !l.stopped {
+		l.timers[timer.id] = timer
+		timer.timer = time.AfterFunc(delay, func() {
+			l.submit(func() {
+				l.__fireTimer(timer)
+			})
+		})
+	}
+	return timer.id
+}
+
+// setImmediate queues the callback to run after the currently queued
+// jobs. It can be cleared using clearTimer until it was executed.
+func (l *eventLoop) setImmediate(callback func()) int64 {
+	l.mutex.Lock()
+	l.nextId++
+	timer := &eventLoopTimer{
+		id:       l.nextId,
+		callback: callback,
+	}
+	if !l.stopped {
+		l.timers[timer.id] = timer
+	}
+	l.mutex.Unlock()
+
+	l.submit(func() {
+		l.__fireTimer(timer)
+	})
+	return timer.id
+}
+
+func (l *eventLoop) clearTimer(id int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if timer, ok := l.timers[id]; ok {
+		if timer.timer != nil {
+			timer.timer.Stop()
+		}
+		delete(l.timers, id)
+	}
+}
+
+func (l *eventLoop) __fireTimer(timer *eventLoopTimer) {
+	l.mutex.Lock()
+	if l.timers[timer.id] != timer {
+		// Cleared after it fired but before it was executed
+		l.mutex.Unlock()
+		return
+	}
+	if timer.repeat {
+		timer.timer.Reset(timer.delay)
+	} else {
+		delete(l.timers, timer.id)
+	}
+	l.mutex.Unlock()
+
+	timer.callback()
+}
+
+func (l *eventLoop) __run() {
+	for {
+		l.mutex.Lock()
+		if l.stopped || len(l.queue) == 0 {
+			l.running = false
+			l.idle.Broadcast()
+			l.mutex.Unlock()
+			return
+		}
+		job := l.queue[0]
+		l.queue = l.queue[1:]
+		l.mutex.Unlock()
+
+		chain := job.chain
+		if chain == nil {
+			chain = &callChain{}
+		}
+		l.__hold(chain)
+		l.budget.run(job.timeout, func() {
+			l.__tryRun(job.run)
+		})
+		l.__hold(nil)
+	}
+}
+
+func (l *eventLoop) __tryRun(job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("EventLoop: Job of bundle %s panicked: %v", l.bundle.Name(), r)
+		}
+	}()
+
+	job()
+}
+
+func (l *eventLoop) __tryExecute(job func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	return job()
+}
+
+// CallBundle runs the function on the event loop of the target bundle and
+// waits for it. Script runtimes must only be used by their own event
+// loop, therefore sandboxes run all calls crossing bundles through it.
+// Called from a job of the caller, the function runs as part of the
+// caller's call chain, so calls back into the caller don't block. Panics
+// of the function are propagated to the caller.
+func CallBundle(caller, target Bundle, function func()) error {
+	targetLoop := target.getEventLoop()
+	if targetLoop == nil {
+		return errEventLoopStopped
+	}
+
+	var chain *callChain
+	if callerLoop := caller.getEventLoop(); callerLoop != nil {
+		chain = callerLoop.currentChain()
+	}
+
+	panicked, recovered := false, interface{}(nil)
+	err := targetLoop.call(chain, func() error {
+		defer func() {
+			if panicked {
+				recovered = recover()
+			}
+		}()
+		panicked = true
+		function()
+		panicked = false
+		return nil
+	})
+	if panicked {
+		panic(recovered)
+	}
+	return err
+}
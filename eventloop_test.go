@@ -0,0 +1,177 @@
+package gomini_test
+
+import (
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+	"github.com/relationsone/gomini/sbgoja"
+	"github.com/spf13/afero"
+)
+
+// scriptIndex wraps the given statements into the execute function of
+// an entrypoint module
+func scriptIndex(statements string) string {
+	return `
+		System.register([], function (exports_1) {
+			return {
+				setters: [],
+				execute: function () {
+					` + statements + `
+				}
+			};
+		});
+	`
+}
+
+func TestTimersFireInOrderOfTheirDeadlines(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": scriptIndex(`
+			setTimeout(report, 60, "late");
+			setTimeout(function () { report("early"); }, 20);
+			setImmediate(function () { report("immediate"); });
+			var cancelled = setTimeout(function () { report("cancelled"); }, 10);
+			clearTimeout(cancelled);
+			report("sync");
+		`),
+	})
+	tk.start()
+
+	tk.expectReports("sync", "immediate", "early", "late")
+	tk.expectNoReport(50 * time.Millisecond)
+}
+
+func TestIntervalsRepeatUntilCleared(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": scriptIndex(`
+			var count = 0;
+			var interval = setInterval(function () {
+				count++;
+				report(count);
+				if (count === 3) {
+					clearInterval(interval);
+				}
+			}, 5);
+		`),
+	})
+	tk.start()
+
+	tk.expectReports(1, 2, 3)
+	tk.expectNoReport(50 * time.Millisecond)
+}
+
+func TestStoppingBundlesCancelsTheirTimers(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": scriptIndex(`
+			setInterval(function () { report("tick"); }, 5);
+		`),
+	})
+	tk.start()
+	tk.expectReports("tick")
+
+	if err := tk.StopBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	// Ticks queued before the bundle stopped may still be reported
+	deadline := time.After(100 * time.Millisecond)
+	for drained := false; !drained; {
+		select {
+		case <-tk.reports:
+		case <-deadline:
+			drained = true
+		}
+	}
+	tk.expectNoReport(50 * time.Millisecond)
+}
+
+func TestPromiseReactionsRunBeforeTheNextJob(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": scriptIndex(`
+			setTimeout(function () { report("timer"); }, 0);
+			Promise.resolve("reaction").then(function (value) {
+				report(value);
+				return Promise.reject(new Error("rejected"));
+			}).catch(function (e) {
+				report(e.message);
+			});
+			new Promise(function (resolve) {
+				setTimeout(resolve, 20, "resolved by a timer");
+			}).then(report);
+			report("sync");
+		`),
+	})
+	tk.start()
+
+	tk.expectReports("sync", "reaction", "rejected", "timer", "resolved by a timer")
+}
+
+func TestAsyncFunctions(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": scriptIndex(`
+			function delay(value) {
+				return new Promise(function (resolve) {
+					setTimeout(resolve, 5, value);
+				});
+			}
+			async function sum() {
+				var a = await delay(1);
+				var b = await delay(2);
+				try {
+					await Promise.reject(new Error("awaited rejection"));
+				} catch (e) {
+					report(e.message);
+				}
+				return a + b;
+			}
+			sum().then(report);
+		`),
+	})
+	tk.start()
+
+	tk.expectReports("awaited rejection", 3)
+}
+
+func TestTranspilerTargets(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	// The stub compiler replaces TARGET by the target it was called with
+	writeFile(t, tk.filesystem, "/js/typescript.js", `
+		var ts = {
+			version: "test",
+			transpileModule: function (source, options) {
+				return {outputText: source.replace("TARGET", options.compilerOptions.target), diagnostics: []};
+			},
+			sys: {
+				write: function () {}
+			}
+		};
+	`)
+	tk.writeBundle("default", nil, map[string]string{"index.ts": scriptIndex(`report("TARGET");`)})
+	tk.writeBundle("legacy", map[string]interface{}{"target": "ES5"}, map[string]string{"index.ts": scriptIndex(`report("TARGET");`)})
+	tk.writeBundle("unsupported", map[string]interface{}{"target": "esnext"}, map[string]string{"index.ts": scriptIndex(`report("TARGET");`)})
+	tk.start()
+
+	reported := map[string]interface{}{}
+	for i := 0; i < 2; i++ {
+		report := tk.nextReport()
+		reported[report.bundleId] = report.value
+	}
+	if reported["default"] != "es2017" || reported["legacy"] != "es5" {
+		t.Fatalf("unexpected targets %v", reported)
+	}
+	tk.awaitStatus("unsupported", gomini.BundleStatusFailed)
+
+	_, err := gomini.New(gomini.KernelConfig{
+		NewKernelFilesystem: func(baseFilesystem afero.Fs) (afero.Fs, error) {
+			return tk.filesystem, nil
+		},
+		NewSandbox:       sbgoja.NewSandbox,
+		TranspilerTarget: "es2020",
+	})
+	if err == nil {
+		t.Fatal("kernel accepted a target unsupported by the sandbox")
+	}
+}
@@ -0,0 +1,439 @@
+package gomini
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/go-errors/errors"
+	"github.com/apex/log"
+)
+
+const (
+	executionLimitTimeout  = "timeout"
+	executionLimitCpuShare = "cpu_share"
+
+	cpuShareWindow       = 10 * time.Second
+	maxLimitViolations   = 3
+	limitViolationWindow = time.Minute
+	limitGracePeriod     = 100 * time.Millisecond
+
+	memoryMeasureInterval = 2 * time.Second
+
+	defaultLifecycleTimeout = 10 * time.Second
+)
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// limitsConfig is the "limits" section of the bundle.json. The timeout
+// is given in the notation of time.ParseDuration, e.g. "250ms", the
+// memory, the I/O bandwidth (per second) and the data quota either in
+// bytes or with a binary unit, e.g. "16MB".
+type limitsConfig struct {
+	Timeout     string  `json:"timeout"`
+	CpuShare    float64 `json:"cpu_share"`
+	Memory      string  `json:"memory"`
+	IOBandwidth string  `json:"io_bandwidth"`
+	DataQuota   string  `json:"data_quota"`
+}
+
+// newBundleLimits parses the limits declared by a bundle and caps them
+// by the limits of the kernel policy.
+func newBundleLimits(config *limitsConfig, policy BundleLimits) (BundleLimits, error) {
+	limits := BundleLimits{}
+	if config != nil {
+		var err error
+		if limits.ExecutionTimeout, err = parseDurationOrDefault(config.Timeout, 0); err != nil {
+			return limits, err
+		}
+		limits.CpuShare = config.CpuShare
+		if limits.MaxMemory, err = parseByteSize(config.Memory); err != nil {
+			return limits, err
+		}
+		if limits.IOBandwidth, err = parseByteSize(config.IOBandwidth); err != nil {
+			return limits, err
+		}
+		if limits.DataQuota, err = parseByteSize(config.DataQuota); err != nil {
+			return limits, err
+		}
+		if err := checkBundleLimits(limits); err != nil {
+			return limits, err
+		}
+	}
+
+	if policy.ExecutionTimeout > 0 && (limits.ExecutionTimeout == 0 || limits.ExecutionTimeout > policy.ExecutionTimeout) {
+		limits.ExecutionTimeout = policy.ExecutionTimeout
+	}
+	if policy.CpuShare > 0 && (limits.CpuShare == 0 || limits.CpuShare > policy.CpuShare) {
+		limits.CpuShare = policy.CpuShare
+	}
+	if policy.MaxMemory > 0 && (limits.MaxMemory == 0 || limits.MaxMemory > policy.MaxMemory) {
+		limits.MaxMemory = policy.MaxMemory
+	}
+	if policy.IOBandwidth > 0 && (limits.IOBandwidth == 0 || limits.IOBandwidth > policy.IOBandwidth) {
+		limits.IOBandwidth = policy.IOBandwidth
+	}
+	if policy.DataQuota > 0 && (limits.DataQuota == 0 || limits.DataQuota > policy.DataQuota) {
+		limits.DataQuota = policy.DataQuota
+	}
+	return limits, nil
+}
+
+func parseByteSize(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	number, multiplier := strings.ToUpper(strings.TrimSpace(value)), uint64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("illegal byte size: %s", value))
+	}
+	return size * multiplier, nil
+}
+
+func checkBundleLimits(limits BundleLimits) error {
+	if limits.ExecutionTimeout < 0 {
+		return errors.New("execution timeout must not be negative")
+	}
+	if limits.CpuShare < 0 || limits.CpuShare > 1 {
+		return errors.New(fmt.Sprintf("illegal cpu share: %v", limits.CpuShare))
+	}
+	return nil
+}
+
+func (l BundleLimits) timeLimited() bool {
+	return l.ExecutionTimeout > 0 || l.CpuShare > 0
+}
+
+// within returns the limits with the execution timeout capped by the
+// given timeout, a zero timeout leaves the limits unchanged
+func (l BundleLimits) within(timeout time.Duration) BundleLimits {
+	if timeout > 0 && (l.ExecutionTimeout == 0 || l.ExecutionTimeout > timeout) {
+		l.ExecutionTimeout = timeout
+	}
+	return l
+}
+
+// findExecutionLimitError returns the ExecutionLimitError the given
+// error was caused by, also through the interruption error of the sandbox.
+func findExecutionLimitError(err error) *ExecutionLimitError {
+	for err != nil {
+		if e, ok := err.(*ExecutionLimitError); ok {
+			return e
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return nil
+		}
+		err = wrapper.Unwrap()
+	}
+	return nil
+}
+
+// executionBudget enforces the BundleLimits of a bundle on its event
+// loop. Every job is granted the execution timeout or the remaining cpu
+// share of the current window, whichever is smaller. A job exceeding it
+// gets an ExecutionLimitError thrown, which scripts can catch, and is
+// interrupted if it keeps running for the grace period. Bundles exceeding
+// their limits repeatedly are moved to BundleStatusFailed. Additionally the memory
+// usage is measured after jobs and while a job calls native functions, a
+// job exceeding the memory limit is interrupted and its bundle is failed
+// right away. A job allocating without ever calling a native function is
+// only stopped by its time limits.
+//
+// Time a job spends waiting for the I/O throttler of the bundle is not
+// accounted, see exclude.
+type executionBudget struct {
+	bundle      *bundle
+	mutex       sync.Mutex
+	windowStart time.Time
+	used        time.Duration
+	violations  []time.Time
+	running     bool
+	interrupted *ExecutionLimitError
+	lastMeasure time.Time
+	sandbox     Sandbox
+	limit       *ExecutionLimitError
+	timer       *time.Timer
+	remaining   time.Duration
+	resumed     time.Time
+	excluded    time.Duration
+	waiting     int
+	exceeded    error
+}
+
+func newExecutionBudget(bundle *bundle) *executionBudget {
+	return &executionBudget{
+		bundle: bundle,
+	}
+}
+
+func (b *executionBudget) run(timeout time.Duration, job func()) {
+	// The limits are read on every job, as they are assigned after the
+	// event loop was created
+	limits := b.bundle.limits.within(timeout)
+	sandbox := b.bundle.Sandbox()
+	if sandbox == nil {
+		job()
+		return
+	}
+
+	if limits.timeLimited() {
+		b.__runLimited(sandbox, limits, job)
+	} else {
+		job()
+	}
+
+	err := b.exceeded
+	if err != nil {
+		b.exceeded = nil
+		sandbox.ClearInterrupt()
+	} else {
+		err = b.checkMemory(false)
+	}
+	if err != nil {
+		log.Errorf("EventLoop: %s", err.Error())
+		b.bundle.kernel.bundleManager.__crashBundle(b.bundle, err)
+	}
+}
+
+// checkMemoryInJob is the native call hook of the sandbox, it interrupts
+// a job as soon as the bundle exceeds its memory limit
+func (b *executionBudget) checkMemoryInJob() {
+	if b.exceeded != nil {
+		return
+	}
+	if err := b.checkMemory(false); err != nil {
+		b.exceeded = err
+		b.bundle.sandbox.Interrupt(err)
+	}
+}
+
+// checkMemory measures the memory usage of the bundle, unless forced at
+// most once per interval, and returns a MemoryLimitError if the usage
+// exceeds the limit. Bundles without a memory limit are never measured,
+// as walking the sandbox's heap is expensive. It must only be called on
+// the event loop.
+func (b *executionBudget) checkMemory(force bool) error {
+	limit := b.bundle.limits.MaxMemory
+	if limit == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if !force && now.Sub(b.lastMeasure) < memoryMeasureInterval {
+		return nil
+	}
+	b.lastMeasure = now
+
+	usage, err := b.bundle.sandbox.MemoryUsage(b.bundle.__moduleExports()...)
+	if err != nil {
+		log.Warnf("EventLoop: Measuring the memory usage of bundle %s failed: %s", b.bundle.Name(), err.Error())
+		return nil
+	}
+	atomic.StoreUint64(&b.bundle.memoryUsage, usage)
+
+	if usage > limit {
+		return &MemoryLimitError{
+			BundleID: b.bundle.ID(),
+			Usage:    usage,
+			Limit:    limit,
+		}
+	}
+	return nil
+}
+
+func (b *executionBudget) __runLimited(sandbox Sandbox, limits BundleLimits, job func()) {
+	start := time.Now()
+	limit := b.__allowance(limits, start)
+
+	b.mutex.Lock()
+	b.running = true
+	b.interrupted = nil
+	b.sandbox = sandbox
+	b.limit = limit
+	b.remaining = limit.Allowed
+	b.excluded = 0
+	b.waiting = 0
+	// The cpu share of the current window may be used up already
+	b.__resume(start)
+	b.mutex.Unlock()
+
+	job()
+
+	// After running is reset no further interrupt can happen, therefore
+	// a pending interruption can be cleared safely
+	b.mutex.Lock()
+	b.running = false
+	interrupted := b.interrupted
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	excluded := b.excluded
+	b.mutex.Unlock()
+
+	b.used += time.Since(start) - excluded
+
+	if interrupted != nil {
+		sandbox.ClearInterrupt()
+		b.__violated(interrupted)
+	}
+}
+
+func (b *executionBudget) __allowance(limits BundleLimits, now time.Time) *ExecutionLimitError {
+	var limit *ExecutionLimitError
+	if limits.ExecutionTimeout > 0 {
+		limit = &ExecutionLimitError{
+			BundleID: b.bundle.ID(),
+			Limit:    executionLimitTimeout,
+			Allowed:  limits.ExecutionTimeout,
+		}
+	}
+
+	if limits.CpuShare > 0 {
+		if now.Sub(b.windowStart) >= cpuShareWindow {
+			b.windowStart = now
+			b.used = 0
+		}
+
+		remaining := time.Duration(limits.CpuShare*float64(cpuShareWindow)) - b.used
+		if remaining < 0 {
+			remaining = 0
+		}
+		if limit == nil || remaining < limit.Allowed {
+			limit = &ExecutionLimitError{
+				BundleID: b.bundle.ID(),
+				Limit:    executionLimitCpuShare,
+				Allowed:  remaining,
+			}
+		}
+	}
+	return limit
+}
+
+// exclude runs the wait, e.g. for the bandwidth of the I/O throttler,
+// without accounting its duration to the running job. The job cannot be
+// interrupted while it waits.
+func (b *executionBudget) exclude(wait func() error) error {
+	b.mutex.Lock()
+	if !b.running || b.limit == nil {
+		b.mutex.Unlock()
+		return wait()
+	}
+	if b.waiting == 0 {
+		b.__pause(time.Now())
+	}
+	b.waiting++
+	b.mutex.Unlock()
+
+	waitStart := time.Now()
+	err := wait()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.waiting--
+	if b.waiting == 0 {
+		now := time.Now()
+		b.excluded += now.Sub(waitStart)
+		b.__resume(now)
+	}
+	return err
+}
+
+// __pause stops the interrupt timer and remembers the remaining allowance,
+// the caller must hold the budget's mutex
+func (b *executionBudget) __pause(now time.Time) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.remaining -= now.Sub(b.resumed)
+}
+
+// __resume schedules the interruption after the remaining allowance, the
+// caller must hold the budget's mutex
+func (b *executionBudget) __resume(now time.Time) {
+	b.resumed = now
+	if b.remaining <= 0 {
+		b.__interrupt()
+		return
+	}
+
+	// Timers stopped by a pause or the end of the job may fire anyway
+	var timer *time.Timer
+	timer = time.AfterFunc(b.remaining, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if b.timer == timer {
+			b.__interrupt()
+		}
+	})
+	b.timer = timer
+}
+
+// __interrupt throws the exceeded limit into the running job and, once
+// the grace period is over, interrupts it. The caller must hold the
+// budget's mutex.
+func (b *executionBudget) __interrupt() {
+	if !b.running {
+		return
+	}
+	if b.interrupted == nil {
+		b.interrupted = b.limit
+		b.sandbox.Throw(b.limit)
+		b.remaining = limitGracePeriod
+		b.__resume(time.Now())
+		return
+	}
+	b.sandbox.Interrupt(b.limit)
+}
+
+// accounted reports if the limit was exceeded by the running job and is
+// therefore accounted as a violation after the job
+func (b *executionBudget) accounted(limit *ExecutionLimitError) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.running && b.interrupted == limit
+}
+
+func (b *executionBudget) __violated(limit *ExecutionLimitError) {
+	log.Warnf("EventLoop: %s", limit.Error())
+
+	// Forget violations which happened outside of the window
+	now := time.Now()
+	violations := make([]time.Time, 0, len(b.violations)+1)
+	for _, violation := range b.violations {
+		if now.Sub(violation) < limitViolationWindow {
+			violations = append(violations, violation)
+		}
+	}
+	b.violations = append(violations, now)
+
+	if len(b.violations) >= maxLimitViolations {
+		log.Errorf("EventLoop: Bundle %s exceeded its execution limits %d times within %s",
+			b.bundle.Name(), len(b.violations), limitViolationWindow)
+		b.violations = nil
+		b.bundle.kernel.bundleManager.__crashBundle(b.bundle, limit)
+	}
+}
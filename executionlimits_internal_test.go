@@ -0,0 +1,79 @@
+package gomini
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBundleLimits(t *testing.T) {
+	limits, err := newBundleLimits(&limitsConfig{
+		Timeout:     "250ms",
+		CpuShare:    0.5,
+		Memory:      "16MB",
+		IOBandwidth: "64 KB",
+		DataQuota:   "1024",
+	}, BundleLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := BundleLimits{
+		ExecutionTimeout: 250 * time.Millisecond,
+		CpuShare:         0.5,
+		MaxMemory:        16 << 20,
+		IOBandwidth:      64 << 10,
+		DataQuota:        1024,
+	}
+	if limits != expected {
+		t.Fatalf("unexpected limits %+v", limits)
+	}
+
+	// Kernel limits cap the declared ones and apply to bundles without limits
+	policy := BundleLimits{ExecutionTimeout: time.Second, MaxMemory: 8 << 20}
+	if limits, err = newBundleLimits(&limitsConfig{Timeout: "2s", Memory: "4MB"}, policy); err != nil {
+		t.Fatal(err)
+	}
+	if limits.ExecutionTimeout != time.Second || limits.MaxMemory != 4<<20 {
+		t.Fatalf("unexpected limits %+v", limits)
+	}
+	if limits, err = newBundleLimits(nil, policy); err != nil {
+		t.Fatal(err)
+	}
+	if limits != policy {
+		t.Fatalf("unexpected limits %+v", limits)
+	}
+
+	for _, illegal := range []*limitsConfig{
+		{Timeout: "-1s"},
+		{Timeout: "forever"},
+		{CpuShare: 1.5},
+		{CpuShare: -0.1},
+		{Memory: "16 apples"},
+		{IOBandwidth: "-5"},
+	} {
+		if _, err := newBundleLimits(illegal, BundleLimits{}); err == nil {
+			t.Errorf("limits %+v were accepted", illegal)
+		}
+	}
+}
+
+func TestFindExecutionLimitError(t *testing.T) {
+	limit := &ExecutionLimitError{BundleID: "app", Limit: executionLimitTimeout, Allowed: time.Second}
+	if findExecutionLimitError(wrappedError{limit}) != limit {
+		t.Fatal("wrapped limit error wasn't found")
+	}
+	if findExecutionLimitError(wrappedError{nil}) != nil {
+		t.Fatal("limit error found in an unrelated error")
+	}
+}
+
+type wrappedError struct {
+	cause error
+}
+
+func (e wrappedError) Error() string {
+	return "wrapped"
+}
+
+func (e wrappedError) Unwrap() error {
+	return e.cause
+}
@@ -0,0 +1,192 @@
+package gomini_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+	"github.com/spf13/afero"
+)
+
+func limits(values map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"limits": values}
+}
+
+func TestRunawayScriptsGetACatchableError(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", limits(map[string]interface{}{"timeout": "50ms"}), map[string]string{
+		"index.ts": scriptIndex(`
+			// The error is thrown at the next call of a native function
+			try {
+				while (true) {
+					clearTimeout(0);
+				}
+			} catch (e) {
+				report(e.name);
+			}
+			setTimeout(report, 10, "alive");
+		`),
+	})
+	tk.start()
+
+	tk.expectReports("ExecutionLimitError", "alive")
+	if status := tk.bundle("app").Status(); status != gomini.BundleStatusStarted {
+		t.Fatalf("bundle is %s after a single violation", status)
+	}
+}
+
+func TestRepeatedViolationsFailTheBundle(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", limits(map[string]interface{}{"timeout": "20ms"}), map[string]string{
+		"index.ts": scriptIndex(`
+			setInterval(function () {
+				// Ignoring the error gets the job interrupted
+				while (true) {
+					try {
+						clearTimeout(0);
+					} catch (e) {
+					}
+				}
+			}, 1);
+			report("started");
+		`),
+	})
+	tk.start()
+	tk.expectReports("started")
+
+	bundle := tk.awaitStatus("app", gomini.BundleStatusFailed)
+	if !strings.Contains(bundle.Failure().Error(), "timeout limit") {
+		t.Fatalf("unexpected failure %s", bundle.Failure())
+	}
+}
+
+func TestInterruptedCallbacksDontCrashTheBundle(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", limits(map[string]interface{}{"timeout": "20ms"}), map[string]string{
+		"index.ts": scriptIndex(`
+			// Loops without native calls can only be interrupted
+			setTimeout(function () {
+				while (true) {}
+			}, 1);
+			setTimeout(report, 200, "alive");
+		`),
+	})
+	tk.start()
+
+	tk.expectReports("alive")
+	if status := tk.bundle("app").Status(); status != gomini.BundleStatusStarted {
+		t.Fatalf("bundle is %s after a single violation", status)
+	}
+}
+
+func TestRunawayEntrypointsDontBlockTheKernel(t *testing.T) {
+	tk := newTestKernel(t, func(config *gomini.KernelConfig) {
+		config.LifecycleTimeout = 100 * time.Millisecond
+	})
+	// Bundles without limits are still bound by the lifecycle timeout
+	tk.writeBundle("a", nil, map[string]string{
+		"index.ts": scriptIndex(`
+			while (true) {
+			}
+		`),
+	})
+	tk.writeBundle("b", nil, map[string]string{"index.ts": reportingIndex("started")})
+	tk.start()
+	tk.expectReports("started")
+
+	bundle := tk.awaitStatus("a", gomini.BundleStatusFailed)
+	if !strings.Contains(bundle.Failure().Error(), "timeout limit") {
+		t.Fatalf("unexpected failure %s", bundle.Failure())
+	}
+}
+
+func TestMemoryUsageIsMeasured(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	// Values only captured by closures are invisible, exported ones count
+	index := scriptIndex(`
+		exports_1("data", new Array(100001).join("x"));
+		setInterval(function () {}, 1000);
+		report("started");
+	`)
+	tk.writeBundle("limited", limits(map[string]interface{}{"memory": "16MB"}), map[string]string{"index.ts": index})
+	tk.writeBundle("unlimited", nil, map[string]string{"index.ts": index})
+	tk.start()
+	tk.expectReports("started", "started")
+
+	if usage := tk.bundle("limited").MemoryUsage(); usage < 100000 {
+		t.Fatalf("memory usage of %d bytes was measured", usage)
+	}
+	// Bundles without a memory limit are not measured
+	if usage := tk.bundle("unlimited").MemoryUsage(); usage != 0 {
+		t.Fatalf("memory usage of %d bytes was measured", usage)
+	}
+}
+
+func TestMemoryLimitFailsTheBundle(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("loading", limits(map[string]interface{}{"memory": "64KB"}), map[string]string{
+		"index.ts": scriptIndex(`
+			globalThis.data = new Array(100001).join("x");
+			report("loaded");
+		`),
+	})
+	// The job never ends on its own, it is interrupted on a native call
+	tk.writeBundle("growing", limits(map[string]interface{}{"memory": "256KB"}), map[string]string{
+		"index.ts": scriptIndex(`
+			var data = [];
+			exports_1("data", data);
+			setTimeout(function () {
+				for (var i = 0; ; i++) {
+					data.push("element " + i);
+					clearTimeout(0);
+				}
+			}, 5);
+		`),
+	})
+	tk.start()
+	tk.expectReports("loaded")
+
+	for _, id := range []string{"loading", "growing"} {
+		bundle := tk.awaitStatus(id, gomini.BundleStatusFailed)
+		if !strings.Contains(bundle.Failure().Error(), "exceeds its limit") {
+			t.Fatalf("unexpected failure of %s: %s", id, bundle.Failure())
+		}
+	}
+}
+
+// readFileApi lets scripts read files through the bundle's filesystem,
+// which is throttled if the bundle has an I/O bandwidth limit
+func readFileApi(config *gomini.KernelConfig) {
+	config.BundleApiProviders = append(config.BundleApiProviders,
+		func(kernel gomini.Bundle, bundle gomini.Bundle, builder gomini.ObjectCreator) {
+			builder.DefineFunction("readFile", "readFile", func(call gomini.FunctionCall) gomini.Value {
+				data, err := afero.ReadFile(bundle.Filesystem(), call.Argument(0).String())
+				if err != nil {
+					panic(err)
+				}
+				return bundle.ToValue(len(data))
+			})
+		})
+}
+
+func TestThrottledIODoesNotCountAgainstTheTimeout(t *testing.T) {
+	tk := newTestKernel(t, readFileApi)
+	tk.writeBundle("app", limits(map[string]interface{}{"timeout": "100ms", "io_bandwidth": "2KB"}), map[string]string{
+		"index.ts": scriptIndex(`
+			var start = Date.now();
+			report(readFile("/data.txt"));
+			report(Date.now() - start);
+		`),
+		"data.txt": strings.Repeat("x", 1024),
+	})
+	tk.start()
+
+	tk.expectReports(1024)
+	// Reading took longer than the timeout, but the bundle keeps running
+	if elapsed, ok := tk.nextReport().value.(int64); !ok || elapsed < 100 {
+		t.Fatalf("reading the throttled file took %vms", elapsed)
+	}
+	if status := tk.bundle("app").Status(); status != gomini.BundleStatusStarted {
+		t.Fatalf("bundle is %s after reading a throttled file", status)
+	}
+}
@@ -0,0 +1,67 @@
+package gomini_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+)
+
+// importingIndex statically imports the given specifier and reports
+// once it was linked
+func importingIndex(specifier string) string {
+	return `
+		System.register(["` + specifier + `"], function (exports_1) {
+			return {
+				setters: [function (m) {}],
+				execute: function () {
+					report("imported");
+				}
+			};
+		});
+	`
+}
+
+func writeExportingBundle(tk *testKernel) {
+	tk.writeBundle("exporting", map[string]interface{}{
+		"name": "com.acme.exporting",
+		"exports": map[string]string{
+			"api":    "/api.ts",
+			"script": "/script.js",
+		},
+	}, map[string]string{
+		"index.ts":  reportingIndex("exporting"),
+		"api.ts":    reportingIndex("api"),
+		"script.js": reportingIndex("script"),
+	})
+}
+
+func TestFailingImportsOfExportedModules(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	writeExportingBundle(tk)
+	tk.writeBundle("unrequired", nil, map[string]string{
+		"index.ts": importingIndex("bundle:com.acme.exporting/api"),
+	})
+	tk.writeBundle("unknown", requires(map[string]string{"id": "exporting"}), map[string]string{
+		"index.ts": importingIndex("bundle:com.acme.exporting/unknown"),
+	})
+	// Unprivileged bundles cannot load JavaScript files, not even exported ones
+	tk.writeBundle("script", requires(map[string]string{"id": "exporting"}), map[string]string{
+		"index.ts": importingIndex("bundle:com.acme.exporting/script"),
+	})
+	tk.start()
+	tk.expectReports("exporting")
+
+	failures := map[string]string{
+		"unrequired": "must be declared as requirement of",
+		"unknown":    "does not export unknown",
+		"script":     "cannot resolve module /script.js exported as script",
+	}
+	for id, expected := range failures {
+		failure := tk.awaitStatus(id, gomini.BundleStatusFailed).Failure()
+		if failure == nil || !strings.Contains(failure.Error(), expected) {
+			t.Fatalf("unexpected failure of %s: %v", id, failure)
+		}
+	}
+	tk.expectNoReport(50 * time.Millisecond)
+}
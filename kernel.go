@@ -14,15 +14,22 @@ import (
 	"github.com/apex/log"
 )
 
-const kernelId = "76141a6c-0aec-4973-b04b-8fdd54753e03"
+const (
+	kernelId      = "76141a6c-0aec-4973-b04b-8fdd54753e03"
+	kernelVersion = "0.1.11"
+)
 
 type kernel struct {
 	*bundle
-	bundleManager  *bundleManager
-	keyManager     KeyManager
-	kernelConfig   KernelConfig
-	resourceLoader ResourceLoader
-	scriptCache    map[string]Script
+	bundleManager   *bundleManager
+	keyManager      KeyManager
+	kernelConfig    KernelConfig
+	resourceLoader  ResourceLoader
+	scriptCache     map[string]Script
+	statusListeners *bundleStatusListeners
+	tsHelpers       Script
+	kernelModules   map[string]KernelModule
+	auditLog        *auditLog
 }
 
 func New(kernelConfig KernelConfig) (Kernel, error) {
@@ -35,6 +42,18 @@ func New(kernelConfig KernelConfig) (Kernel, error) {
 	if kernelConfig.NewSandbox == nil {
 		return nil, errors.New("no NewSandbox function defined")
 	}
+	if err := checkBundleLimits(kernelConfig.BundleLimits); err != nil {
+		return nil, err
+	}
+	if kernelConfig.LifecycleTimeout < 0 {
+		return nil, errors.New("lifecycle timeout must not be negative")
+	}
+	if kernelConfig.LifecycleTimeout == 0 {
+		kernelConfig.LifecycleTimeout = defaultLifecycleTimeout
+	}
+	if kernelConfig.PolicyProvider == nil {
+		kernelConfig.PolicyProvider = NewDefaultPolicyProvider()
+	}
 	if kernelConfig.BundleApiProviders == nil {
 		kernelConfig.BundleApiProviders = []ApiProviderBinder{}
 	}
@@ -44,13 +63,17 @@ func New(kernelConfig KernelConfig) (Kernel, error) {
 	}
 
 	kernel := &kernel{
-		kernelConfig:   kernelConfig,
-		resourceLoader: newResourceLoader(),
-		scriptCache:    make(map[string]Script),
+		kernelConfig:    kernelConfig,
+		keyManager:      kernelConfig.KeyManager,
+		resourceLoader:  newResourceLoader(),
+		scriptCache:     make(map[string]Script),
+		statusListeners: newBundleStatusListeners(),
+		kernelModules:   make(map[string]KernelModule),
+		auditLog:        newAuditLog(kernelConfig.AuditLog),
 	}
 
 	apiBinders := kernelConfig.BundleApiProviders
-	apiBinders = append(apiBinders, consoleApi(), timeoutApi())
+	apiBinders = append(apiBinders, consoleApi(), timeoutApi(), bundlesApi())
 
 	kernel.bundleManager = newBundleManager(kernel, apiBinders)
 
@@ -64,6 +87,19 @@ func New(kernelConfig KernelConfig) (Kernel, error) {
 	}
 
 	kernel.bundle = bundle
+	if kernel.bundle.version, err = ParseVersion(kernelVersion); err != nil {
+		return nil, err
+	}
+	if kernelConfig.TranspilerTarget != "" {
+		target, err := checkScriptTarget(kernelConfig.TranspilerTarget, kernel.sandbox.ScriptTarget())
+		if err != nil {
+			return nil, err
+		}
+		kernel.kernelConfig.TranspilerTarget = target
+	}
+	if kernel.tsHelpers, _, err = kernel.sandbox.Compile("embedded://tshelpers.js", tsHelpersSource); err != nil {
+		return nil, err
+	}
 	if err := kernel.bundle.init(kernel); err != nil {
 		return nil, errors.New(err)
 	}
@@ -103,12 +139,71 @@ func (k *kernel) Start(entryPoint string) error {
 }
 
 func (k *kernel) Stop() error {
+	k.setBundleStatus(BundleStatusStopping)
 	if err := k.bundleManager.stop(); err != nil {
 		return err
 	}
+	k.eventLoop.stop()
+	k.setBundleStatus(BundleStatusStopped)
 	return nil
 }
 
+func (k *kernel) Bundles() []Bundle {
+	return k.bundleManager.listBundles()
+}
+
+func (k *kernel) Bundle(id string) Bundle {
+	return k.bundleManager.lookupBundle(id)
+}
+
+func (k *kernel) InstallBundle(path string) (Bundle, error) {
+	bundle, err := k.bundleManager.installBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func (k *kernel) UninstallBundle(id string) error {
+	return k.bundleManager.withBundle(id, k.bundleManager.uninstallBundle)
+}
+
+func (k *kernel) StartBundle(id string) error {
+	return k.bundleManager.withBundle(id, k.bundleManager.startBundle)
+}
+
+func (k *kernel) StopBundle(id string) error {
+	return k.bundleManager.withBundle(id, k.bundleManager.stopBundle)
+}
+
+func (k *kernel) RestartBundle(id string) error {
+	return k.bundleManager.withBundle(id, k.bundleManager.restartBundle)
+}
+
+func (k *kernel) UpdateBundle(id, path string, options BundleUpdateOptions) error {
+	return k.bundleManager.updateBundle(id, path, options)
+}
+
+func (k *kernel) RepositoryBundles() ([]RepositoryBundle, error) {
+	return k.bundleManager.repositoryBundles()
+}
+
+func (k *kernel) InstallFromRepository(id, version string) (Bundle, error) {
+	bundle, err := k.bundleManager.installFromRepository(id, version)
+	if err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func (k *kernel) UpdateFromRepository(id, version string, options BundleUpdateOptions) error {
+	return k.bundleManager.updateFromRepository(id, version, options)
+}
+
+func (k *kernel) AddBundleStatusListener(listener BundleStatusListener) func() {
+	return k.statusListeners.add("", listener)
+}
+
 func (k *kernel) Privileged() bool {
 	return true
 }
@@ -119,17 +214,28 @@ func (k *kernel) SecurityInterceptor() SecurityInterceptor {
 			return true
 		}
 
-		moduleName := strings.ToUpper(strings.Split(property, ".")[0])
-		privilege := fmt.Sprintf("PRIVILEGE_%s", moduleName)
-		privileges := caller.Privileges()
-		for _, p := range privileges {
+		segments, _, _ := splitPropertyPath(property)
+		moduleName := segments[0]
+
+		// PRIVILEGE_<MODULE> grants the complete module
+		granted := caller.isPermitted(property)
+		privilege := fmt.Sprintf("PRIVILEGE_%s", strings.ToUpper(moduleName))
+		for _, p := range caller.Privileges() {
 			if p == privilege {
-				return true
+				granted = true
 			}
 		}
+		if !granted {
+			return false
+		}
 
-		//TODO: add real checks here
-		return false
+		// Kernel modules may restrict the access further
+		if kernelModule, ok := k.kernelModules[moduleName]; ok {
+			if interceptor := kernelModule.SecurityInterceptor(); interceptor != nil {
+				return interceptor(caller, property)
+			}
+		}
+		return true
 	}
 }
 
@@ -144,7 +250,10 @@ func (k *kernel) entryPoint(filename string) error {
 	}
 
 	if filename != "" {
-		_, err = k.loadScriptModule(id.String(), "entrypoint", "/", &resolvedScriptPath{filename, k.bundle}, k.bundle)
+		err = k.eventLoop.execute(func() error {
+			_, err := k.loadScriptModule(id.String(), "entrypoint", "/", &resolvedScriptPath{filename, k.bundle}, k.bundle)
+			return err
+		})
 		if err != nil {
 			return err
 		}
@@ -169,6 +278,7 @@ func (k *kernel) loadKernelModule(kernelModule KernelModule) error {
 	}
 	module.kernel = true
 	k.addModule(module)
+	k.kernelModules[kernelModule.Name()] = kernelModule
 
 	k.defineKernelModule(module, module.Origin().FullPath(), func(exports Object) {
 		binder := kernelModule.KernelModuleBinder()
@@ -195,7 +305,27 @@ func (k *kernel) defineKernelModule(module Module, filename string, exporter fun
 	module.Bundle().FreezeObject(module.getModuleExports())
 }
 
+// loadScriptModule loads a module and its complete module graph. All
+// modules of the graph are registered first, then linked to the exports
+// of their dependencies and executed in dependency order, which allows
+// import cycles as long as no module uses a binding of the cycle before
+// it was initialized.
 func (k *kernel) loadScriptModule(id, name, parentPath string, scriptPath *resolvedScriptPath, bundle *bundle) (Module, error) {
+	module, err := k.registerScriptModule(id, name, parentPath, scriptPath, bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.__instantiateModule(module, bundle); err != nil {
+		return nil, errors.New(err)
+	}
+
+	return module, nil
+}
+
+// registerScriptModule executes the module's script, which registers the
+// module's declaration, without linking or executing the module itself
+func (k *kernel) registerScriptModule(id, name, parentPath string, scriptPath *resolvedScriptPath, bundle *bundle) (*module, error) {
 	//loadingBundle := bundle
 
 	filename := scriptPath.path
@@ -222,15 +352,26 @@ func (k *kernel) loadScriptModule(id, name, parentPath string, scriptPath *resol
 
 	// We expect a cleanly compiled module, that doesn't return anything
 	val, err := bundle.Sandbox().Execute(prog)
+	if err != nil {
+		// Record the import chain before the module is popped from the loader stack
+		err = k.__newModuleLoadError(err, bundle)
+	}
 
 	bundle.popLoaderStack()
 
+	// The module stays registered, later imports must see the failure
+	// instead of empty exports
 	if err != nil {
-		return nil, errors.New(err)
+		return nil, module.fail(errors.New(err))
 	}
 
 	if val != bundle.Undefined() && val != bundle.Null() {
-		return nil, errors.New(fmt.Sprintf("Modules are not supposed to return anything: %s", val.Export()))
+		return nil, module.fail(errors.New(fmt.Sprintf("Modules are not supposed to return anything: %s", val.Export())))
+	}
+
+	// Plain scripts without a System.register declaration are complete
+	if module.state == moduleLoading {
+		module.state = moduleExecuted
 	}
 
 	return module, nil
@@ -259,74 +400,6 @@ func (k *kernel) loadContent(bundle Bundle, filesystem afero.Fs, filename string
 	return b, nil
 }
 
-func (k *kernel) registerModule(module *module, dependencies []string, callback func(export func(name string, value Value), context Object) Object, bundle *bundle) error {
-	log.Debugf("Kernel: Loading module %s (%s) into bundle %s (%s)", module.Name(), module.ID(), bundle.Name(), bundle.ID())
-
-	exportFunction := func(name string, value Value) {
-		module.getModuleExports().DefineConstant(name, value)
-	}
-
-	if len(dependencies) > 0 {
-		log.Debugf("Kernel: Bundle %s has injection request: [%s]", bundle.Name(), strings.Join(dependencies, ", "))
-	}
-
-	dependentModules := make([]Module, len(dependencies))
-	for i, dependency := range dependencies {
-		dependentModule, err := k.__resolveDependencyModule(dependency, bundle, module)
-		if err != nil {
-			return err
-		}
-
-		dependentModules[i] = dependentModule
-	}
-
-	context := module.Bundle().NewObject()
-	context.DefineConstant("id", module.ID())
-
-	initializer := callback(exportFunction, context)
-
-	var setters []Callable
-	if err := module.export(initializer.Get("setters"), &setters); err != nil {
-		panic(err)
-	}
-
-	execute := initializer.Get("execute")
-
-	for i, setter := range setters {
-		m := dependentModules[i]
-
-		exports := m.getModuleExports()
-		if m.Bundle().ID() != bundle.ID() {
-			log.Debugf("Kernel: Create security proxy from '%s:/%s' to '%s:/%s'",
-				bundle.Name(), module.Origin().FullPath(), m.Bundle().Name(), m.Origin().FullPath())
-
-			moduleProxy, err := m.Bundle().Sandbox().NewModuleProxy(m.getModuleExports(), m.Name(), bundle)
-			if err != nil {
-				panic(err)
-			}
-			exports = moduleProxy
-		}
-
-		if _, err := setter(k.Undefined(), exports); err != nil {
-			panic(err)
-		}
-	}
-
-	var executable Callable
-	if err := module.export(execute, &executable); err != nil {
-		panic(err)
-	}
-
-	// Register the actual classes
-	log.Debugf("Kernel: Executing initializer of module: %s", module.Name())
-
-	if _, err := executable(execute); err != nil {
-		panic(err)
-	}
-
-	return nil
-}
-
 func (k *kernel) loadScriptSource(scriptPath *resolvedScriptPath, allowCaching bool) (Script, error) {
 	cacheFilename := tsCacheFilename(scriptPath.path, scriptPath.loader, k)
 
@@ -5,10 +5,76 @@ import (
 	"github.com/apex/log"
 	"github.com/go-errors/errors"
 	"path/filepath"
+	"fmt"
+	"strings"
+	"os"
+	"github.com/spf13/afero"
 )
 
+// moduleLoadError remembers the script stack trace and the chain of
+// modules being loaded when the innermost module failed to execute.
+type moduleLoadError struct {
+	cause       error
+	stackTrace  string
+	importChain []string
+}
+
+func (e *moduleLoadError) Error() string {
+	return e.cause.Error()
+}
+
+func (k *kernel) __newModuleLoadError(err error, bundle *bundle) error {
+	// The innermost module already recorded the full chain
+	if findModuleLoadError(err) != nil {
+		return err
+	}
+
+	return &moduleLoadError{
+		cause:       err,
+		stackTrace:  bundle.Sandbox().ErrorStackTrace(err),
+		importChain: k.__importChain(bundle),
+	}
+}
+
+func (k *kernel) __importChain(bundle *bundle) []string {
+	chain := make([]string, 0, len(bundle.loaderStack))
+	for _, moduleId := range bundle.loaderStack {
+		if module := bundle.findModuleById(moduleId); module != nil {
+			chain = append(chain, fmt.Sprintf("%s:/%s", bundle.Name(), module.Origin().FullPath()))
+		}
+	}
+	return chain
+}
+
+func findModuleLoadError(err error) *moduleLoadError {
+	for err != nil {
+		switch e := err.(type) {
+		case *moduleLoadError:
+			return e
+		case *errors.Error:
+			err = e.Err
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
 func (k *kernel) __resolveDependencyModule(dependency string, bundle *bundle, module *module) (Module, error) {
+	if strings.HasPrefix(dependency, bundleImportPrefix) {
+		return k.__resolveExportedModule(dependency, bundle)
+	}
+
 	scriptPath := k.resolveScriptPath(bundle, dependency)
+	if scriptPath == nil {
+		return nil, errors.New(fmt.Sprintf("cannot resolve module %s in bundle %s", dependency, bundle.Name()))
+	}
+
+	// Dynamic imports without an importing module are resolved from the root
+	importerPath, importerFile := "/", "/"
+	if module != nil {
+		importerPath, importerFile = module.Origin().Path(), module.Origin().FullPath()
+	}
 
 	vfs, file, err := k.__toVirtualKernelFile(scriptPath)
 	if err != nil {
@@ -21,7 +87,7 @@ func (k *kernel) __resolveDependencyModule(dependency string, bundle *bundle, mo
 			dependency, kernelModule.Bundle().Name(), kernelModule.Origin().FullPath())
 
 		log.Debugf("Kernel: Needs security proxy for exported modules '%s:/%s' to '%s:/%s'",
-			bundle.Name(), module.Origin().FullPath(), kernelModule.Bundle().Name(), kernelModule.Origin().FullPath())
+			bundle.Name(), importerFile, kernelModule.Bundle().Name(), kernelModule.Origin().FullPath())
 
 		if err == nil {
 			// We panic if access not granted
@@ -41,10 +107,12 @@ func (k *kernel) __resolveDependencyModule(dependency string, bundle *bundle, mo
 
 		log.Debugf("Kernel: Resolved dependency %s [%s:/%s]*", dependency, scriptPath.loader.Name(), scriptPath.path)
 
+		// The module is only registered, it is linked and executed together
+		// with the module graph of the importing module
 		moduleId := id.String()
-		m, err := k.loadScriptModule(moduleId, dependency, module.origin.Path(), scriptPath, bundle)
+		m, err := k.registerScriptModule(moduleId, dependency, importerPath, scriptPath, bundle)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		return m, nil
@@ -58,6 +126,118 @@ func (k *kernel) __resolveDependencyModule(dependency string, bundle *bundle, mo
 	return dependentModule, nil
 }
 
+// __resolveExportedModule resolves imports like "bundle:com.acme.sensors/api"
+// to the module the bundle with the given name exports under that name. The
+// exporting bundle has to be required by the importing bundle and must be
+// started.
+func (k *kernel) __resolveExportedModule(dependency string, bundle *bundle) (Module, error) {
+	name, exportName := splitBundleImport(dependency)
+
+	target := k.bundleManager.findBundleByName(name)
+	if target == nil {
+		return nil, errors.New(fmt.Sprintf("bundle %s is not installed", name))
+	}
+	if !bundle.requires(target.ID()) {
+		return nil, errors.New(fmt.Sprintf("bundle %s must be declared as requirement of %s to import %s",
+			name, bundle.Name(), dependency))
+	}
+
+	// Scripts and modules of the exporting bundle must only be used on its
+	// event loop, the importing bundle waits for it as part of its chain.
+	// A bundle stopped in the meantime rejects the call.
+	var exported *module
+	var err error
+	callErr := CallBundle(bundle, target, func() {
+		exported, err = k.__loadExportedModule(dependency, exportName, target)
+	})
+	if errors.Is(callErr, errEventLoopStopped) {
+		return nil, errors.New(fmt.Sprintf("bundle %s is not started (%s)", name, target.Status()))
+	}
+	if callErr != nil {
+		return nil, callErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Kernel: Resolved dependency %s [exported module '%s:/%s']",
+		dependency, target.Name(), exported.Origin().FullPath())
+
+	exportedModule := newExportedModule(exported, qualifiedExportName(target.Name(), exportName))
+	if err := exportedModule.IsAccessible(bundle); err != nil {
+		return nil, err
+	}
+	return exportedModule, nil
+}
+
+// __loadExportedModule returns the executed module exported by the target
+// under the given name and loads it on first use. It must only be called
+// on the target's event loop.
+func (k *kernel) __loadExportedModule(dependency, exportName string, target *bundle) (*module, error) {
+	if target.Status() != BundleStatusStarted {
+		return nil, errors.New(fmt.Sprintf("bundle %s is not started (%s)", target.Name(), target.Status()))
+	}
+
+	path, ok := target.config.Exports[exportName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("bundle %s does not export %s", target.Name(), exportName))
+	}
+
+	scriptPath := k.resolveScriptPath(target, path)
+	if scriptPath == nil {
+		return nil, errors.New(fmt.Sprintf("cannot resolve module %s exported as %s by bundle %s",
+			path, exportName, target.Name()))
+	}
+
+	exported := target.findModuleByModuleFile(scriptPath.path)
+	if exported == nil {
+		moduleId, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugf("Kernel: Loading exported module %s [%s:/%s]*", dependency, target.Name(), scriptPath.path)
+
+		m, err := k.loadScriptModule(moduleId.String(), exportName, "/", scriptPath, target)
+		if err != nil {
+			return nil, err
+		}
+		exported = m.(*module)
+	}
+	if err := k.__checkExportedModule(dependency, exported, target); err != nil {
+		return nil, err
+	}
+	return exported, nil
+}
+
+// __invalidateScriptCache drops the pre-transpiled and compiled scripts
+// of all files of the given bundle. Update slots are reused, therefore
+// cached scripts of a previous version would be picked up otherwise.
+func (k *kernel) __invalidateScriptCache(bundle *bundle) error {
+	return afero.Walk(bundle.Filesystem(), "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// The kernel's virtual type definitions are mounted into every
+			// bundle, their parent directory only exists as a mount point
+			if path == KernelVfsTypesPath || path == filepath.Dir(KernelVfsTypesPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		cacheFilename := tsCacheFilename(path, bundle, k)
+		delete(k.scriptCache, cacheFilename)
+
+		err = k.filesystem.Remove(filepath.Join(KernelVfsCachePath, cacheFilename))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
 func (k *kernel) __loadSource(bundle Bundle, filename string) (string, error) {
 	if isTypeScript(filename) {
 		// Is pre-transpiled?
@@ -108,10 +288,88 @@ func (k *kernel) __toVirtualKernelFile(scriptPath *resolvedScriptPath) (bool, *k
 	if err != nil {
 		return false, nil, err
 	}
-	switch ff := f.(type) {
+	defer f.Close()
+
+	// Bundles with an I/O bandwidth limit wrap all their files
+	file := f
+	if throttled, ok := file.(*throttledFile); ok {
+		file = throttled.File
+	}
+	switch ff := file.(type) {
 	case *compositeFile:
 		e, success := ff.file.(*kernelFile)
 		return success && !e.dir, e, nil
 	}
 	return false, nil, nil
 }
+
+// __importModule loads a module on demand and returns a promise of its
+// exports, it backs import() expressions and System.import. Relative
+// specifiers are resolved relative to the importing module, which is
+// identified by its id, or relative to the bundle's root without one.
+func (k *kernel) __importModule(bundle *bundle, importerId, specifier string) Value {
+	promise, resolve, reject := bundle.sandbox.NewPromise()
+
+	// Like with ES modules the imported module is never evaluated in the
+	// middle of the importing script, but as a later job
+	submitted := bundle.eventLoop.submit(func() {
+		exports, err := k.__tryImportModule(bundle, importerId, specifier)
+		if err != nil {
+			log.Warnf("Kernel: Dynamic import of %s into bundle %s failed: %s", specifier, bundle.Name(), err.Error())
+			err = reject(bundle.NewException(err))
+		} else {
+			err = resolve(exports)
+		}
+		if err != nil {
+			bundle.crash(err)
+		}
+	})
+	if !submitted {
+		reject(bundle.NewException(errEventLoopStopped))
+	}
+	return promise
+}
+
+func (k *kernel) __tryImportModule(bundle *bundle, importerId, specifier string) (exports Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	var importer *module
+	if importerId != "" {
+		if importer = bundle.findModuleById(importerId); importer == nil {
+			return nil, errors.New(fmt.Sprintf("importing module %s is unknown to bundle %s", importerId, bundle.Name()))
+		}
+
+		// Specifiers are resolved relative to the module on top of the loader stack
+		bundle.pushLoaderStack(importerId)
+		defer bundle.popLoaderStack()
+	}
+
+	imported, err := k.__resolveDependencyModule(specifier, bundle, importer)
+	if err != nil {
+		return nil, err
+	}
+
+	if local := localModule(imported, bundle); local != nil {
+		if err := k.__instantiateModule(local, bundle); err != nil {
+			return nil, err
+		}
+	}
+
+	exports = imported.getModuleExports()
+	if imported.Bundle().ID() != bundle.ID() {
+		sandbox, err := availableSandbox(imported.Bundle())
+		if err != nil {
+			return nil, err
+		}
+		return sandbox.NewModuleProxy(exports, imported.Name(), bundle)
+	}
+	return exports, nil
+}
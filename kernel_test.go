@@ -0,0 +1,231 @@
+package gomini_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+	"github.com/relationsone/gomini/sbgoja"
+	"github.com/spf13/afero"
+)
+
+// testTypeScript replaces the bundled TypeScript compiler, the scripts of
+// the tests are written in the System.register format already
+const testTypeScript = `
+var ts = {
+	version: "test",
+	transpileModule: function (source) {
+		return {outputText: source, diagnostics: []};
+	},
+	sys: {
+		write: function () {}
+	}
+};
+`
+
+const reportTimeout = 5 * time.Second
+
+type testReport struct {
+	bundleId string
+	value    interface{}
+}
+
+// testKernel runs a kernel on an in-memory filesystem. Scripts pass
+// values to the test using the global report function.
+type testKernel struct {
+	gomini.Kernel
+	t          *testing.T
+	filesystem afero.Fs
+	reports    chan testReport
+}
+
+func newTestKernel(t *testing.T, configure func(config *gomini.KernelConfig)) *testKernel {
+	filesystem := afero.NewMemMapFs()
+	writeFile(t, filesystem, "/js/typescript.js", testTypeScript)
+	kernelPaths := []string{
+		gomini.KernelVfsAppsPath, gomini.KernelVfsCachePath, gomini.KernelVfsTypesPath, gomini.KernelVfsWritablePath,
+	}
+	for _, path := range kernelPaths {
+		if err := filesystem.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tk := &testKernel{
+		t:          t,
+		filesystem: filesystem,
+		reports:    make(chan testReport, 100),
+	}
+
+	config := gomini.KernelConfig{
+		NewKernelFilesystem: func(baseFilesystem afero.Fs) (afero.Fs, error) {
+			return filesystem, nil
+		},
+		NewSandbox: sbgoja.NewSandbox,
+		BundleApiProviders: []gomini.ApiProviderBinder{
+			func(kernel gomini.Bundle, bundle gomini.Bundle, builder gomini.ObjectCreator) {
+				builder.DefineFunction("report", "report", func(call gomini.FunctionCall) gomini.Value {
+					var value interface{}
+					if len(call.Arguments) > 0 {
+						value = call.Argument(0).Export()
+					}
+					tk.reports <- testReport{bundle.ID(), value}
+					return bundle.Undefined()
+				})
+			},
+		},
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	kernel, err := gomini.New(config)
+	if err != nil {
+		t.Fatalf("creating the kernel failed: %s", err)
+	}
+	tk.Kernel = kernel
+	return tk
+}
+
+// start starts the kernel and all bundles written so far and stops the
+// kernel at the end of the test
+func (tk *testKernel) start() {
+	if err := tk.Start(""); err != nil {
+		tk.t.Fatalf("starting the kernel failed: %s", err)
+	}
+	tk.t.Cleanup(func() {
+		tk.Stop()
+	})
+}
+
+// writeBundle writes a bundle with the given configuration and scripts
+// to the apps directory and returns its path
+func (tk *testKernel) writeBundle(id string, config map[string]interface{}, files map[string]string) string {
+	return writeBundle(tk.t, tk.filesystem, filepath.Join(gomini.KernelVfsAppsPath, id), id, config, files)
+}
+
+func writeBundle(t *testing.T, filesystem afero.Fs, path, id string, config map[string]interface{}, files map[string]string) string {
+	bundleConfig := map[string]interface{}{
+		"id":         id,
+		"name":       id,
+		"version":    "1.0.0",
+		"entrypoint": "/index.ts",
+	}
+	for key, value := range config {
+		bundleConfig[key] = value
+	}
+
+	content, err := json.Marshal(bundleConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filesystem, filepath.Join(path, "bundle.json"), string(content))
+	for filename, source := range files {
+		writeFile(t, filesystem, filepath.Join(path, filename), source)
+	}
+	return path
+}
+
+func writeFile(t *testing.T, filesystem afero.Fs, filename, content string) {
+	if err := filesystem.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(filesystem, filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextReport waits for the next value reported by any bundle
+func (tk *testKernel) nextReport() testReport {
+	select {
+	case report := <-tk.reports:
+		return report
+	case <-time.After(reportTimeout):
+		tk.t.Fatalf("no report within %s", reportTimeout)
+		return testReport{}
+	}
+}
+
+// expectReports waits for the given values to be reported in order
+func (tk *testKernel) expectReports(values ...interface{}) {
+	for _, expected := range values {
+		report := tk.nextReport()
+		if !sameValue(report.value, expected) {
+			tk.t.Fatalf("bundle %s reported %#v, expected %#v", report.bundleId, report.value, expected)
+		}
+	}
+}
+
+func (tk *testKernel) expectNoReport(wait time.Duration) {
+	select {
+	case report := <-tk.reports:
+		tk.t.Fatalf("unexpected report of bundle %s: %#v", report.bundleId, report.value)
+	case <-time.After(wait):
+	}
+}
+
+func (tk *testKernel) bundle(id string) gomini.Bundle {
+	bundle := tk.Bundle(id)
+	if bundle == nil {
+		tk.t.Fatalf("bundle %s is not installed", id)
+	}
+	return bundle
+}
+
+// awaitStatus waits until the bundle reaches the given status
+func (tk *testKernel) awaitStatus(id string, status gomini.BundleStatus) gomini.Bundle {
+	deadline := time.Now().Add(reportTimeout)
+	for {
+		bundle := tk.Bundle(id)
+		if bundle != nil && bundle.Status() == status {
+			return bundle
+		}
+		if time.Now().After(deadline) {
+			current := "not installed"
+			if bundle != nil {
+				current = bundle.Status().String()
+			}
+			tk.t.Fatalf("bundle %s didn't reach status %s, it is %s", id, status, current)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sameValue compares exported script values, numbers are exported as
+// int64 or float64 depending on their value
+func sameValue(actual, expected interface{}) bool {
+	switch e := expected.(type) {
+	case int:
+		return sameValue(actual, float64(e))
+	case float64:
+		switch a := actual.(type) {
+		case int64:
+			return float64(a) == e
+		case float64:
+			return a == e
+		}
+		return false
+	}
+	return actual == expected
+}
+
+func TestKernelStartsBundles(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": `
+			System.register([], function (exports_1) {
+				return {
+					setters: [],
+					execute: function () {
+						report("started");
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+
+	tk.expectReports("started")
+	tk.awaitStatus("app", gomini.BundleStatusStarted)
+}
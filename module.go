@@ -3,8 +3,11 @@ package gomini
 import (
 	"github.com/go-errors/errors"
 	"path/filepath"
+	"strings"
 )
 
+const bundleImportPrefix = "bundle:"
+
 func newOrigin(filename string) Origin {
 	path := filepath.Dir(filename)
 	filename = filepath.Base(filename)
@@ -31,6 +34,32 @@ func (o *moduleOrigin) FullPath() string {
 	return filepath.Clean(filepath.Join(o.path, o.filename))
 }
 
+// moduleState tracks a script module through the loading phases. The
+// module's script registers its declaration, then the dependencies of
+// the whole module graph are resolved and registered, the modules are
+// linked to the exports of their dependencies and finally executed in
+// dependency order.
+type moduleState int
+
+const (
+	moduleLoading moduleState = iota
+	moduleRegistered
+	moduleResolving
+	moduleResolved
+	moduleLinked
+	moduleExecuting
+	moduleExecuted
+	moduleFailed
+)
+
+var moduleStateNames = [...]string{"loading", "registered", "resolving", "resolved", "linked", "executing", "executed", "failed"}
+
+func (s moduleState) String() string {
+	return moduleStateNames[s]
+}
+
+type moduleDeclaration func(export func(name string, value Value), context Object) Object
+
 type module struct {
 	id      string
 	name    string
@@ -38,6 +67,14 @@ type module struct {
 	bundle  Bundle
 	exports Object
 	kernel  bool
+
+	state        moduleState
+	failure      error
+	dependencies []string
+	declaration  moduleDeclaration
+	imports      []Module
+	execute      Callable
+	bindings     map[string]Value
 }
 
 func newModule(moduleId, name string, origin Origin, bundle Bundle) (*module, error) {
@@ -46,11 +83,12 @@ func newModule(moduleId, name string, origin Origin, bundle Bundle) (*module, er
 	}
 
 	module := &module{
-		id:      moduleId,
-		name:    name,
-		origin:  origin,
-		bundle:  bundle,
-		exports: bundle.Sandbox().NewObject(),
+		id:       moduleId,
+		name:     name,
+		origin:   origin,
+		bundle:   bundle,
+		exports:  bundle.Sandbox().NewObject(),
+		bindings: make(map[string]Value),
 	}
 
 	return module, nil
@@ -73,7 +111,11 @@ func (m *module) Bundle() Bundle {
 }
 
 func (m *module) IsAccessible(caller Bundle) error {
-	return m.bundle.Sandbox().IsAccessible(m, caller)
+	sandbox, err := availableSandbox(m.bundle)
+	if err != nil {
+		return err
+	}
+	return sandbox.IsAccessible(m, caller)
 }
 
 func (m *module) getModuleExports() Object {
@@ -84,6 +126,66 @@ func (m *module) export(value Value, target interface{}) error {
 	return m.bundle.Sandbox().Export(value, target)
 }
 
+// bind sets the value of an exported binding. Bindings are read-only
+// accessors on the module's exports, later assignments only replace the
+// value returned to the importers.
+func (m *module) bind(name string, value Value) {
+	if _, ok := m.bindings[name]; !ok {
+		m.exports.DefineAccessorProperty(name, func() interface{} {
+			return m.bindings[name].Unwrap()
+		}, nil)
+	}
+	m.bindings[name] = value
+}
+
 func (m *module) setName(name string) {
 	m.name = name
 }
+
+// fail marks the module as failed, later imports of the module report
+// the same error
+func (m *module) fail(err error) error {
+	m.state = moduleFailed
+	m.failure = err
+	return err
+}
+
+// exportedModule exposes a module of an app bundle to other bundles
+// under its qualified export name, e.g. "bundle:com.acme.sensors/api".
+type exportedModule struct {
+	*module
+	qualifiedName string
+}
+
+func newExportedModule(module *module, qualifiedName string) *exportedModule {
+	return &exportedModule{
+		module:        module,
+		qualifiedName: qualifiedName,
+	}
+}
+
+func (e *exportedModule) Name() string {
+	return e.qualifiedName
+}
+
+func (e *exportedModule) IsAccessible(caller Bundle) error {
+	sandbox, err := availableSandbox(e.bundle)
+	if err != nil {
+		return err
+	}
+	return sandbox.IsAccessible(e, caller)
+}
+
+func qualifiedExportName(bundleName, exportName string) string {
+	return bundleImportPrefix + bundleName + "/" + exportName
+}
+
+// splitBundleImport splits "bundle:<name>/<export>" into the bundle name and
+// the export name. Without an export name "index" is assumed.
+func splitBundleImport(dependency string) (string, string) {
+	reference := strings.TrimPrefix(dependency, bundleImportPrefix)
+	if index := strings.Index(reference, "/"); index != -1 {
+		return reference[:index], reference[index+1:]
+	}
+	return reference, "index"
+}
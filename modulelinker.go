@@ -0,0 +1,241 @@
+package gomini
+
+import (
+	"github.com/apex/log"
+	"github.com/go-errors/errors"
+	"fmt"
+	"strings"
+)
+
+// __instantiateModule resolves, links and executes the module graph of a
+// registered module, like SystemJS does for System.register modules.
+// Modules in an import cycle see the bindings of each other as soon as
+// they are linked, while the values are filled in when the exporting
+// module executes.
+func (k *kernel) __instantiateModule(module *module, bundle *bundle) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	if err := k.__resolveModuleGraph(module, bundle); err != nil {
+		return err
+	}
+	if err := k.__linkModuleGraph(module, bundle); err != nil {
+		return err
+	}
+	return k.__executeModuleGraph(module, bundle)
+}
+
+// __resolveModuleGraph resolves the dependencies of the module and
+// registers all modules of the bundle reachable from it. Modules already
+// being resolved are part of an import cycle and skipped.
+func (k *kernel) __resolveModuleGraph(module *module, bundle *bundle) error {
+	if module.state != moduleRegistered {
+		return nil
+	}
+	module.state = moduleResolving
+
+	log.Debugf("Kernel: Loading module %s (%s) into bundle %s (%s)", module.Name(), module.ID(), bundle.Name(), bundle.ID())
+
+	if len(module.dependencies) > 0 {
+		log.Debugf("Kernel: Bundle %s has injection request: [%s]", bundle.Name(), strings.Join(module.dependencies, ", "))
+	}
+
+	bundle.pushLoaderStack(module.ID())
+	defer bundle.popLoaderStack()
+
+	module.imports = make([]Module, len(module.dependencies))
+	for i, dependency := range module.dependencies {
+		dependentModule, err := k.__tryResolveDependencyModule(dependency, bundle, module)
+		if err != nil {
+			return module.fail(k.__newModuleLoadError(err, bundle))
+		}
+		module.imports[i] = dependentModule
+
+		if local := localModule(dependentModule, bundle); local != nil {
+			if err := k.__resolveModuleGraph(local, bundle); err != nil {
+				return module.fail(err)
+			}
+		}
+	}
+
+	module.state = moduleResolved
+	return nil
+}
+
+func (k *kernel) __tryResolveDependencyModule(dependency string, bundle *bundle, module *module) (m Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	return k.__resolveDependencyModule(dependency, bundle, module)
+}
+
+// __linkModuleGraph calls the declarations of the module and all resolved
+// modules reachable from it and passes the exports of the dependencies to
+// their setters. The setters are called again on every later export of
+// a dependency, so bindings stay live.
+func (k *kernel) __linkModuleGraph(module *module, bundle *bundle) error {
+	if module.state != moduleResolved {
+		return nil
+	}
+	module.state = moduleLinked
+
+	bundle.pushLoaderStack(module.ID())
+	defer bundle.popLoaderStack()
+
+	setters, err := k.__declareModule(module, bundle)
+	if err != nil {
+		return module.fail(k.__newModuleLoadError(err, bundle))
+	}
+
+	for _, dependentModule := range module.imports {
+		if local := localModule(dependentModule, bundle); local != nil {
+			if err := k.__linkModuleGraph(local, bundle); err != nil {
+				return module.fail(err)
+			}
+		}
+	}
+
+	for i, setter := range setters {
+		if i >= len(module.imports) {
+			break
+		}
+		m := module.imports[i]
+
+		exports := m.getModuleExports()
+		if m.Bundle().ID() != bundle.ID() {
+			log.Debugf("Kernel: Create security proxy from '%s:/%s' to '%s:/%s'",
+				bundle.Name(), module.Origin().FullPath(), m.Bundle().Name(), m.Origin().FullPath())
+
+			sandbox, err := availableSandbox(m.Bundle())
+			if err != nil {
+				return module.fail(err)
+			}
+			moduleProxy, err := sandbox.NewModuleProxy(m.getModuleExports(), m.Name(), bundle)
+			if err != nil {
+				return module.fail(err)
+			}
+			exports = moduleProxy
+		}
+
+		if _, err := setter(k.Undefined(), exports); err != nil {
+			return module.fail(k.__newModuleLoadError(err, bundle))
+		}
+	}
+
+	return nil
+}
+
+func (k *kernel) __declareModule(module *module, bundle *bundle) (setters []Callable, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.New(r)
+			}
+		}
+	}()
+
+	// Importers hold the module's exports, they see bindings exported
+	// after they were linked, e.g. in an import cycle, without being
+	// notified again
+	exportFunction := func(name string, value Value) {
+		module.bind(name, value)
+	}
+
+	context := module.Bundle().NewObject()
+	context.DefineConstant("id", module.ID())
+	context.DefineFunction("import", "import", func(call FunctionCall) Value {
+		if len(call.Arguments) < 1 {
+			return bundle.NewTypeError("illegal number of arguments")
+		}
+		return k.__importModule(bundle, module.ID(), call.Argument(0).String())
+	})
+
+	initializer := module.declaration(exportFunction, context)
+
+	if err := module.export(initializer.Get("setters"), &setters); err != nil {
+		return nil, err
+	}
+
+	execute := initializer.Get("execute")
+	if err := module.export(execute, &module.execute); err != nil {
+		return nil, err
+	}
+
+	return setters, nil
+}
+
+// __executeModuleGraph executes the dependencies of the module before the
+// module itself. Modules already executing are part of an import cycle,
+// they are linked already and complete their execution further up.
+func (k *kernel) __executeModuleGraph(module *module, bundle *bundle) error {
+	switch module.state {
+	case moduleFailed:
+		return module.failure
+	case moduleLinked:
+	default:
+		return nil
+	}
+	module.state = moduleExecuting
+
+	bundle.pushLoaderStack(module.ID())
+	defer bundle.popLoaderStack()
+
+	for _, dependentModule := range module.imports {
+		if local := localModule(dependentModule, bundle); local != nil {
+			if err := k.__executeModuleGraph(local, bundle); err != nil {
+				return module.fail(err)
+			}
+		}
+	}
+
+	// Register the actual classes
+	log.Debugf("Kernel: Executing initializer of module: %s", module.Name())
+
+	if _, err := module.execute(k.Undefined()); err != nil {
+		return module.fail(k.__newModuleLoadError(err, bundle))
+	}
+
+	module.state = moduleExecuted
+	return nil
+}
+
+// localModule returns the module if it is a script module of the given
+// bundle, which is loaded as part of the bundle's module graph
+func localModule(m Module, bundle *bundle) *module {
+	if local, ok := m.(*module); ok && local.Bundle().ID() == bundle.ID() {
+		return local
+	}
+	return nil
+}
+
+// __checkExportedModule reports exported modules, which are not usable by
+// other bundles. Other bundles only ever see executed modules, an exported
+// module still instantiating is reached through an import cycle back into
+// the exporting bundle, which cannot be linked across the bundles as the
+// importing bundle only receives a proxy of the exports.
+func (k *kernel) __checkExportedModule(dependency string, exported *module, target *bundle) error {
+	switch exported.state {
+	case moduleExecuted:
+		return nil
+	case moduleFailed:
+		return errors.New(fmt.Sprintf("exported module %s failed to load: %s", dependency, exported.failure.Error()))
+	}
+	return errors.New(fmt.Sprintf("cannot link exported module %s of bundle %s, it is part of an import cycle and still %s",
+		dependency, target.Name(), exported.state))
+}
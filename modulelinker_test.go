@@ -0,0 +1,225 @@
+package gomini_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+)
+
+func TestDynamicImportLoadsModulesLazily(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": `
+			System.register([], function (exports_1, context_1) {
+				return {
+					setters: [],
+					execute: function () {
+						report("started");
+						context_1.import("./feature").then(function (feature) {
+							report(feature.name);
+							return System.import("/feature");
+						}).then(function (feature) {
+							// Modules are only executed once
+							report(feature.name);
+						});
+					}
+				};
+			});
+		`,
+		"feature.ts": `
+			System.register([], function (exports_1) {
+				return {
+					setters: [],
+					execute: function () {
+						report("loaded");
+						exports_1("name", "feature");
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+
+	tk.expectReports("started", "loaded", "feature", "feature")
+}
+
+func TestFailedDynamicImportsReject(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": `
+			System.register([], function (exports_1, context_1) {
+				return {
+					setters: [],
+					execute: function () {
+						context_1.import("./broken").catch(function (e) {
+							report("first failed");
+							// Failed modules fail all later imports as well
+							return context_1.import("./broken");
+						}).catch(function (e) {
+							report("second failed");
+							return context_1.import("./missing");
+						}).catch(function (e) {
+							report("missing failed");
+						});
+					}
+				};
+			});
+		`,
+		"broken.ts": `throw new Error("broken");`,
+	})
+	tk.start()
+
+	tk.expectReports("first failed", "second failed", "missing failed")
+}
+
+func TestImportsFromStoppedBundlesReject(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("crashing", map[string]interface{}{
+		"name":    "com.acme.crashing",
+		"exports": map[string]string{"api": "/index.ts"},
+	}, map[string]string{"index.ts": crashingIndex})
+	// Crashed bundles don't stop the bundles requiring them
+	tk.writeBundle("app", requires(map[string]string{"id": "crashing"}), map[string]string{
+		"index.ts": `
+			System.register([], function (exports_1, context_1) {
+				return {
+					setters: [],
+					execute: function () {
+						setTimeout(function () {
+							context_1.import("bundle:com.acme.crashing/api").then(function () {
+								report("imported");
+							}, function (e) {
+								report(String(e));
+							});
+						}, 200);
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+	tk.expectReports("started")
+	tk.awaitStatus("crashing", gomini.BundleStatusFailed)
+
+	if report := tk.nextReport(); !strings.Contains(fmt.Sprint(report.value), "bundle com.acme.crashing is not started") {
+		t.Fatalf("unexpected import result %v", report.value)
+	}
+}
+
+func TestImportCycleSeesLaterExports(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": `
+			System.register(["./a"], function (exports_1) {
+				var a;
+				return {
+					setters: [function (m) { a = m; }],
+					execute: function () {
+						report(a.fromB());
+					}
+				};
+			});
+		`,
+		"a.ts": `
+			System.register(["./b"], function (exports_1) {
+				var b;
+				return {
+					setters: [function (m) { b = m; }],
+					execute: function () {
+						exports_1("fromB", function () { return b.value(); });
+						exports_1("name", "a");
+					}
+				};
+			});
+		`,
+		"b.ts": `
+			System.register(["./a"], function (exports_1) {
+				var a;
+				return {
+					setters: [function (m) { a = m; }],
+					execute: function () {
+						exports_1("value", function () { return "b sees " + a.name; });
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+
+	tk.expectReports("b sees a")
+}
+
+func TestExportedBindingsAreLiveAndReadOnly(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": `
+			System.register(["./counter"], function (exports_1) {
+				"use strict";
+				var counter;
+				return {
+					setters: [function (m) { counter = m; }],
+					execute: function () {
+						counter.increment();
+						counter.increment();
+						report(counter.count);
+						try {
+							counter.count = 100;
+							report("assigned");
+						} catch (e) {
+							report(e instanceof TypeError);
+						}
+						report(counter.count);
+					}
+				};
+			});
+		`,
+		"counter.ts": `
+			System.register([], function (exports_1) {
+				var count;
+				function increment() {
+					count++;
+					exports_1("count", count);
+				}
+				exports_1("increment", increment);
+				return {
+					setters: [],
+					execute: function () {
+						count = 0;
+						exports_1("count", count);
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+
+	tk.expectReports(2, true, 2)
+}
+
+func TestImportCycleExecutesEachModuleOnce(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	cyclic := func(name, dependency string) string {
+		return `
+			System.register(["` + dependency + `"], function (exports_1) {
+				return {
+					setters: [function (m) {}],
+					execute: function () {
+						report("` + name + `");
+					}
+				};
+			});
+		`
+	}
+	tk.writeBundle("app", nil, map[string]string{
+		"index.ts": cyclic("index", "./a"),
+		"a.ts":     cyclic("a", "./b"),
+		"b.ts":     cyclic("b", "./a"),
+	})
+	tk.start()
+
+	// Dependencies execute first, the cycle is entered through a
+	tk.expectReports("b", "a", "index")
+	tk.expectNoReport(50 * time.Millisecond)
+}
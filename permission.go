@@ -0,0 +1,165 @@
+package gomini
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"github.com/go-errors/errors"
+)
+
+const (
+	propertyInject    = "inject"
+	propertyApply     = "apply"
+	propertyConstruct = "construct"
+)
+
+// permission grants access to a property path of a module and to all
+// properties below it, e.g. "files.resolvePath" grants the property paths
+// "files.resolvePath.get" and "files.resolvePath.apply" built by the
+// security proxy. Segments may be "*" to match any single property, so
+// "logger.*" grants the complete logger module, other wildcards are not
+// supported in segments. Importing a module is
+// granted by any permission for the module.
+//
+// Calls can additionally be restricted to resources, which are matched
+// against the first string argument of the call, e.g. the permission
+// "files.resolvePath:/data/**" only allows to resolve paths inside of
+// /data, while the objects returned by such calls are usable without
+// further restriction. In resource patterns "*" matches inside of a path
+// element and "**" matches any number of path elements.
+type permission struct {
+	segments []string
+	resource string
+}
+
+func parsePermissions(values []string) ([]permission, error) {
+	permissions := make([]permission, 0, len(values))
+	for _, value := range values {
+		permission, err := parsePermission(value)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, nil
+}
+
+func parsePermission(value string) (permission, error) {
+	segments, resource, hasResource := splitPropertyPath(value)
+	for _, segment := range segments {
+		if segment == "" {
+			return permission{}, errors.New(fmt.Sprintf("illegal permission: %s", value))
+		}
+		// Segments only match exactly or by "*", patterns would be
+		// silently taken as literal names
+		if segment != "*" && strings.ContainsAny(segment, "*?[\\") {
+			return permission{}, errors.New(fmt.Sprintf("illegal permission, segments must be names or \"*\": %s", value))
+		}
+	}
+	if hasResource {
+		if resource == "" {
+			return permission{}, errors.New(fmt.Sprintf("illegal permission, empty resource: %s", value))
+		}
+		for _, element := range strings.Split(resource, "/") {
+			if _, err := path.Match(element, ""); err != nil {
+				return permission{}, errors.New(fmt.Sprintf("illegal permission, bad resource pattern: %s", value))
+			}
+		}
+	}
+	return permission{
+		segments: segments,
+		resource: resource,
+	}, nil
+}
+
+// grants checks the property path of an access, as built by the security
+// proxy, against the permission.
+func (p permission) grants(property string) bool {
+	segments, resource, _ := splitPropertyPath(property)
+
+	if len(segments) == 2 && segments[1] == propertyInject {
+		return matchSegment(p.segments[0], segments[0])
+	}
+
+	if len(p.segments) > len(segments) {
+		return false
+	}
+	for i, segment := range p.segments {
+		if !matchSegment(segment, segments[i]) {
+			return false
+		}
+	}
+
+	// Only calls of the granted function itself carry the resource,
+	// objects returned by them were already checked
+	operation := segments[len(segments)-1]
+	isCall := operation == propertyApply || operation == propertyConstruct
+	if p.resource != "" && isCall && len(segments) == len(p.segments)+1 {
+		return resource != "" && matchResource(p.resource, cleanResource(resource))
+	}
+	return true
+}
+
+func matchSegment(pattern, segment string) bool {
+	return pattern == "*" || pattern == segment
+}
+
+// splitPropertyPath splits a property path into its segments and the
+// resource. The first segment is always the module name, names of
+// exported modules like "bundle:com.acme.sensors/api" contain dots and
+// colons themselves.
+func splitPropertyPath(property string) ([]string, string, bool) {
+	moduleEnd := 0
+	if strings.HasPrefix(property, bundleImportPrefix) {
+		moduleEnd = len(bundleImportPrefix)
+		if index := strings.Index(property[moduleEnd:], "/"); index != -1 {
+			moduleEnd += index
+		}
+	}
+
+	resource, hasResource := "", false
+	if index := strings.Index(property[moduleEnd:], ":"); index != -1 {
+		resource, hasResource = property[moduleEnd+index+1:], true
+		property = property[:moduleEnd+index]
+	}
+
+	moduleName := property
+	var segments []string
+	if index := strings.Index(property[moduleEnd:], "."); index != -1 {
+		moduleName = property[:moduleEnd+index]
+		segments = strings.Split(property[moduleEnd+index+1:], ".")
+	}
+	return append([]string{moduleName}, segments...), resource, hasResource
+}
+
+func cleanResource(resource string) string {
+	if strings.HasPrefix(resource, "/") {
+		return path.Clean(resource)
+	}
+	return resource
+}
+
+func matchResource(pattern, resource string) bool {
+	return matchResourceElements(strings.Split(pattern, "/"), strings.Split(resource, "/"))
+}
+
+func matchResourceElements(pattern, resource []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(resource); i >= 0; i-- {
+				if matchResourceElements(pattern[1:], resource[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(resource) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], resource[0]); !matched {
+			return false
+		}
+		pattern, resource = pattern[1:], resource[1:]
+	}
+	return len(resource) == 0
+}
@@ -0,0 +1,45 @@
+package gomini
+
+import "testing"
+
+func TestPermissionGrants(t *testing.T) {
+	cases := []struct {
+		permission string
+		property   string
+		granted    bool
+	}{
+		{"logger", "logger.info.apply", true},
+		{"logger", "logger.inject", true},
+		{"logger.*", "logger.debug.get", true},
+		{"logger.info", "logger.info.apply", true},
+		{"logger.info", "logger.debug.apply", false},
+		{"logger.info", "files.inject", false},
+		{"files.*", "files.inject", true},
+		{"files.resolvePath:/data/**", "files.resolvePath.apply:/data/config/app.json", true},
+		{"files.resolvePath:/data/**", "files.resolvePath.apply:/data/../etc/passwd", false},
+		{"files.resolvePath:/data/*.json", "files.resolvePath.apply:/data/app.json", true},
+		{"files.resolvePath:/data/*.json", "files.resolvePath.apply:/data/config/app.json", false},
+		{"files.resolvePath:/data/**", "files.resolvePath.apply", false},
+		// Returned objects are usable without the resource
+		{"files.resolvePath:/data/**", "files.resolvePath.apply.name.get", true},
+		{"bundle:com.acme.lib/api", "bundle:com.acme.lib/api.state.apply", true},
+		{"bundle:com.acme.lib/api.state", "bundle:com.acme.lib/api.reset.apply", false},
+	}
+	for _, c := range cases {
+		permission, err := parsePermission(c.permission)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if granted := permission.grants(c.property); granted != c.granted {
+			t.Errorf("permission %s grants %s: %t, expected %t", c.permission, c.property, granted, c.granted)
+		}
+	}
+}
+
+func TestIllegalPermissions(t *testing.T) {
+	for _, illegal := range []string{"", "logger..info", "log*", "logger.inf?", "files.resolvePath:", "files.resolvePath:/data/["} {
+		if _, err := parsePermission(illegal); err == nil {
+			t.Errorf("illegal permission %q was accepted", illegal)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package gomini
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+)
+
+// AccessRequest describes an access of a bundle to a module of another
+// bundle or of the kernel. Property is the property path built by the
+// security proxy, e.g. "files.resolvePath.apply:/data/config.json", and
+// Module is its first segment.
+type AccessRequest struct {
+	Caller   Bundle
+	Target   Bundle
+	Module   string
+	Property string
+}
+
+// PolicyDecision is the answer of a PolicyProvider, the Reason is
+// reported to the caller when the access is denied.
+type PolicyDecision struct {
+	Allowed bool
+	Reason  string
+}
+
+// PolicyProvider decides on every access crossing a bundle boundary.
+// Implementations are called from the event loops of all bundles
+// concurrently.
+type PolicyProvider interface {
+	Decide(request AccessRequest) PolicyDecision
+}
+
+// NewDefaultPolicyProvider returns the policy used without a configured
+// PolicyProvider. Privileged callers are always allowed, otherwise the
+// SecurityInterceptor of the target bundle decides, which checks the
+// permissions declared by the caller. Custom policies may delegate to it
+// to only restrict the default policy further.
+func NewDefaultPolicyProvider() PolicyProvider {
+	return defaultPolicyProvider{}
+}
+
+type defaultPolicyProvider struct{}
+
+func (defaultPolicyProvider) Decide(request AccessRequest) PolicyDecision {
+	if request.Caller.Privileged() {
+		return PolicyDecision{Allowed: true}
+	}
+
+	interceptor := request.Target.SecurityInterceptor()
+	if interceptor != nil && !interceptor(request.Caller, request.Property) {
+		return PolicyDecision{Reason: "no permission granted"}
+	}
+	return PolicyDecision{Allowed: true}
+}
+
+// CheckAccess asks the PolicyProvider of the kernel whether the caller
+// may access the property path of a module of the target bundle.
+func CheckAccess(caller, target Bundle, property string) error {
+	segments, _, _ := splitPropertyPath(property)
+	request := AccessRequest{
+		Caller:   caller,
+		Target:   target,
+		Module:   segments[0],
+		Property: property,
+	}
+
+	kernel := target.getKernel()
+	decision := kernel.kernelConfig.PolicyProvider.Decide(request)
+	kernel.auditLog.recordAccess(caller, target, property, decision)
+	if !decision.Allowed {
+		reason := decision.Reason
+		if reason == "" {
+			reason = "access denied"
+		}
+		return errors.New(fmt.Sprintf("illegal access violation: %s cannot access %s::%s (%s)",
+			caller.Name(), target.Name(), property, reason))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package gomini_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"github.com/relationsone/gomini"
+)
+
+// allowlistPolicy denies calls not contained in its allowlist and
+// otherwise delegates to the default policy
+type allowlistPolicy struct {
+	allowed  map[string]bool
+	mutex    sync.Mutex
+	requests []gomini.AccessRequest
+}
+
+func (p *allowlistPolicy) Decide(request gomini.AccessRequest) gomini.PolicyDecision {
+	p.mutex.Lock()
+	p.requests = append(p.requests, request)
+	p.mutex.Unlock()
+
+	if strings.HasSuffix(request.Property, ".apply") && !p.allowed[request.Property] {
+		return gomini.PolicyDecision{Reason: "not in the allowlist"}
+	}
+	return gomini.NewDefaultPolicyProvider().Decide(request)
+}
+
+func TestPolicyProviderDecidesOnAccesses(t *testing.T) {
+	policy := &allowlistPolicy{allowed: map[string]bool{"bundle:com.acme.lib/api.state.apply": true}}
+	tk := newTestKernel(t, func(config *gomini.KernelConfig) {
+		config.PolicyProvider = policy
+	})
+	writeLibrary(tk)
+	tk.writeBundle("app", map[string]interface{}{
+		"requires":    []map[string]string{{"id": "lib"}},
+		"permissions": []string{"bundle:com.acme.lib/api"},
+	}, map[string]string{
+		"index.ts": `
+			System.register(["bundle:com.acme.lib/api"], function (exports_1) {
+				var api;
+				return {
+					setters: [function (m) { api = m; }],
+					execute: function () {
+						report(api.state().count);
+						try {
+							api.increment(function () {});
+						} catch (e) {
+							report(e.message);
+						}
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+
+	if count := tk.nextReport().value; !sameValue(count, 0) {
+		t.Fatalf("unexpected count %v", count)
+	}
+	message, _ := tk.nextReport().value.(string)
+	if !strings.Contains(message, "not in the allowlist") {
+		t.Fatalf("unexpected result of a denied call %q", message)
+	}
+
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+	for _, request := range policy.requests {
+		if request.Caller.ID() == "app" && request.Target.ID() == "lib" && request.Module == "bundle:com.acme.lib/api" {
+			return
+		}
+	}
+	t.Fatalf("policy wasn't asked for the accesses of the app in %d requests", len(policy.requests))
+}
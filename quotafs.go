@@ -0,0 +1,231 @@
+package gomini
+
+import (
+	"github.com/go-errors/errors"
+	"io"
+	"os"
+	"sync"
+	"github.com/spf13/afero"
+)
+
+var errQuotaExceeded = errors.New("quota of the data section exceeded")
+
+func isQuotaExceeded(err error) bool {
+	return errors.Is(err, errQuotaExceeded)
+}
+
+// quotaFs restricts the total size of all regular files inside of the
+// wrapped filesystem. The usage is calculated once when the filesystem is
+// created and tracked on every change afterwards. Changes are serialized,
+// which is cheap as a bundle's scripts run on a single event loop anyway.
+type quotaFs struct {
+	afero.Fs
+	quota int64
+	usage int64
+	mutex sync.Mutex
+}
+
+func newQuotaFs(base afero.Fs, quota int64) (*quotaFs, error) {
+	quotaFs := &quotaFs{
+		Fs:    base,
+		quota: quota,
+	}
+	if err := quotaFs.__recalculate(); err != nil {
+		return nil, err
+	}
+	return quotaFs, nil
+}
+
+func (q *quotaFs) Name() string {
+	return "QuotaFs"
+}
+
+func (q *quotaFs) Create(name string) (afero.File, error) {
+	return q.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (q *quotaFs) Open(name string) (afero.File, error) {
+	return q.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (q *quotaFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var truncated int64
+	if flag&os.O_TRUNC != 0 {
+		truncated = q.__fileSize(name)
+	}
+
+	file, err := q.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	q.usage -= truncated
+
+	return &quotaFile{
+		File:    file,
+		quotaFs: q,
+		append:  flag&os.O_APPEND != 0,
+	}, nil
+}
+
+func (q *quotaFs) Remove(name string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	size := q.__fileSize(name)
+	if err := q.Fs.Remove(name); err != nil {
+		return err
+	}
+	q.usage -= size
+	return nil
+}
+
+func (q *quotaFs) RemoveAll(path string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// Partially removed trees are accounted correctly by recalculating
+	err := q.Fs.RemoveAll(path)
+	if recalculateErr := q.__recalculate(); err == nil {
+		err = recalculateErr
+	}
+	return err
+}
+
+func (q *quotaFs) Rename(oldname, newname string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	replaced := q.__fileSize(newname)
+	if err := q.Fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+	q.usage -= replaced
+	return nil
+}
+
+// __fileSize returns the size of the regular file or 0 if the file
+// doesn't exist or isn't a regular file
+func (q *quotaFs) __fileSize(name string) int64 {
+	info, err := q.Fs.Stat(name)
+	if err != nil || !isRegularFile(info) {
+		return 0
+	}
+	return info.Size()
+}
+
+// isRegularFile also checks IsDir, as some filesystems (e.g. afero's
+// MemMapFs) create directories without setting os.ModeDir
+func isRegularFile(info os.FileInfo) bool {
+	return !info.IsDir() && info.Mode().IsRegular()
+}
+
+func (q *quotaFs) __recalculate() error {
+	var usage int64
+	err := afero.Walk(q.Fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == "/" && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if isRegularFile(info) {
+			usage += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	q.usage = usage
+	return nil
+}
+
+// __resize runs the operation, which may change the file's size to at
+// most the given size, if the growth fits into the quota
+func (q *quotaFs) __resize(file afero.File, maxSize func(size int64) (int64, error), operation func() error) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	newSize, err := maxSize(size)
+	if err != nil {
+		return err
+	}
+	if growth := newSize - size; growth > 0 && q.usage+growth > q.quota {
+		return &os.PathError{Op: "write", Path: file.Name(), Err: errQuotaExceeded}
+	}
+
+	err = operation()
+
+	// Account what was actually written, also if the operation failed
+	if info, statErr := file.Stat(); statErr == nil {
+		q.usage += info.Size() - size
+	}
+	return err
+}
+
+type quotaFile struct {
+	afero.File
+	quotaFs *quotaFs
+	append  bool
+}
+
+func (f *quotaFile) Write(p []byte) (n int, err error) {
+	maxSize := func(size int64) (int64, error) {
+		if f.append {
+			return size + int64(len(p)), nil
+		}
+		offset, err := f.File.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		return max64(size, offset+int64(len(p))), nil
+	}
+
+	err = f.quotaFs.__resize(f.File, maxSize, func() error {
+		n, err = f.File.Write(p)
+		return err
+	})
+	return n, err
+}
+
+func (f *quotaFile) WriteAt(p []byte, off int64) (n int, err error) {
+	maxSize := func(size int64) (int64, error) {
+		return max64(size, off+int64(len(p))), nil
+	}
+
+	err = f.quotaFs.__resize(f.File, maxSize, func() error {
+		n, err = f.File.WriteAt(p, off)
+		return err
+	})
+	return n, err
+}
+
+func (f *quotaFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *quotaFile) Truncate(size int64) error {
+	maxSize := func(int64) (int64, error) {
+		return size, nil
+	}
+
+	return f.quotaFs.__resize(f.File, maxSize, func() error {
+		return f.File.Truncate(size)
+	})
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
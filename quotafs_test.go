@@ -0,0 +1,64 @@
+package gomini
+
+import (
+	"os"
+	"testing"
+	"github.com/spf13/afero"
+)
+
+func TestQuotaFs(t *testing.T) {
+	base := afero.NewMemMapFs()
+	if err := afero.WriteFile(base, "/existing", make([]byte, 4), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Existing files count against the quota
+	fs, err := newQuotaFs(base, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.usage != 4 {
+		t.Fatalf("usage of %d bytes was calculated", fs.usage)
+	}
+
+	if err := afero.WriteFile(fs, "/file", make([]byte, 6), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/other", make([]byte, 1), 0644); !isQuotaExceeded(err) {
+		t.Fatalf("write exceeding the quota returned %v", err)
+	}
+
+	// Truncating and overwriting in place frees or reuses space
+	if err := afero.WriteFile(fs, "/file", make([]byte, 2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := fs.OpenFile("/file", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(make([]byte, 6), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(make([]byte, 1), 6); !isQuotaExceeded(err) {
+		t.Fatalf("write exceeding the quota returned %v", err)
+	}
+	file.Close()
+
+	if err := fs.Remove("/existing"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.usage != 6 {
+		t.Fatalf("usage of %d bytes after removal, expected 6", fs.usage)
+	}
+	if err := fs.Rename("/file", "/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/other", make([]byte, 4), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.usage != 0 {
+		t.Fatalf("usage of %d bytes after removing everything", fs.usage)
+	}
+}
@@ -0,0 +1,209 @@
+package gomini
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"github.com/go-errors/errors"
+)
+
+const repositorySignatureSuffix = ".sig"
+
+type repositoryIndex struct {
+	Bundles []RepositoryBundle `json:"bundles"`
+}
+
+// repositorySignature is the detached signature of the index, the
+// fingerprint identifies the public key provided by the KeyManager.
+type repositorySignature struct {
+	Fingerprint string `json:"fingerprint"`
+	Signature   string `json:"signature"`
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+type indexRepository struct {
+	indexURL   *url.URL
+	keyManager KeyManager
+	client     *http.Client
+}
+
+// NewRepository creates a Repository reading a signed JSON index from
+// the configured file:// or http(s):// URL. Indexes are signed using
+// RSA (PKCS#1 v1.5) or ECDSA over the SHA-256 of the index file.
+func NewRepository(config RepositoryConfig) (Repository, error) {
+	if config.KeyManager == nil {
+		return nil, errors.New("no KeyManager defined to verify the repository index")
+	}
+
+	indexURL, err := url.Parse(config.IndexURL)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	switch indexURL.Scheme {
+	case "file", "http", "https":
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported repository url: %s", config.IndexURL))
+	}
+
+	client := config.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &indexRepository{
+		indexURL:   indexURL,
+		keyManager: config.KeyManager,
+		client:     client,
+	}, nil
+}
+
+func (r *indexRepository) Bundles() ([]RepositoryBundle, error) {
+	index, err := r.__readAll(r.indexURL)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureURL := *r.indexURL
+	signatureURL.Path += repositorySignatureSuffix
+	signature, err := r.__readAll(&signatureURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.__verifySignature(index, signature); err != nil {
+		return nil, err
+	}
+
+	repositoryIndex := &repositoryIndex{}
+	if err := json.Unmarshal(index, repositoryIndex); err != nil {
+		return nil, errors.New(err)
+	}
+
+	// Archive urls are relative to the index
+	bundles := make([]RepositoryBundle, len(repositoryIndex.Bundles))
+	for i, bundle := range repositoryIndex.Bundles {
+		archiveURL, err := r.indexURL.Parse(bundle.URL)
+		if err != nil {
+			return nil, errors.New(err)
+		}
+		bundle.URL = archiveURL.String()
+		bundles[i] = bundle
+	}
+	return bundles, nil
+}
+
+func (r *indexRepository) Open(bundle RepositoryBundle) (io.ReadCloser, error) {
+	archiveURL, err := url.Parse(bundle.URL)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	return r.__open(archiveURL)
+}
+
+func (r *indexRepository) __open(location *url.URL) (io.ReadCloser, error) {
+	switch location.Scheme {
+	case "file":
+		file, err := os.Open(location.Path)
+		if err != nil {
+			return nil, errors.New(err)
+		}
+		return file, nil
+
+	case "http", "https":
+		response, err := r.client.Get(location.String())
+		if err != nil {
+			return nil, errors.New(err)
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, errors.New(fmt.Sprintf("could not fetch %s: %s", location, response.Status))
+		}
+		return response.Body, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported repository url: %s", location))
+}
+
+func (r *indexRepository) __readAll(location *url.URL) ([]byte, error) {
+	reader, err := r.__open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	return data, nil
+}
+
+func (r *indexRepository) __verifySignature(index, data []byte) error {
+	signature := &repositorySignature{}
+	if err := json.Unmarshal(data, signature); err != nil {
+		return errors.New(err)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return errors.New(err)
+	}
+
+	key, err := r.keyManager.GetKey(signature.Fingerprint)
+	if err != nil {
+		return err
+	}
+	publicKey, err := parsePublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(index)
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], value); err != nil {
+			return errors.New("repository index signature is invalid")
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		ecdsaSignature := &ecdsaSignature{}
+		if _, err := asn1.Unmarshal(value, ecdsaSignature); err != nil {
+			return errors.New("repository index signature is invalid")
+		}
+		if !ecdsa.Verify(publicKey, digest[:], ecdsaSignature.R, ecdsaSignature.S) {
+			return errors.New("repository index signature is invalid")
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unsupported public key type for key %s", signature.Fingerprint))
+}
+
+// parsePublicKey parses a PEM or DER encoded PKIX public key
+func parsePublicKey(key []byte) (interface{}, error) {
+	if block, _ := pem.Decode(bytes.TrimSpace(key)); block != nil {
+		key = block.Bytes
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(key)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	return publicKey, nil
+}
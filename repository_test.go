@@ -0,0 +1,234 @@
+package gomini_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"github.com/relationsone/gomini"
+)
+
+type testKeyManager map[string][]byte
+
+func (m testKeyManager) GetKey(fingerprint string) ([]byte, error) {
+	if key, ok := m[fingerprint]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown key " + fingerprint)
+}
+
+// testRepository serves a signed index and the bundle archives over HTTP
+type testRepository struct {
+	t        *testing.T
+	key      *ecdsa.PrivateKey
+	files    map[string][]byte
+	bundles  []gomini.RepositoryBundle
+	server   *httptest.Server
+	tampered bool
+}
+
+func newTestRepository(t *testing.T) *testRepository {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := &testRepository{
+		t:     t,
+		key:   key,
+		files: make(map[string][]byte),
+	}
+	repository.server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		content, ok := repository.files[request.URL.Path]
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Write(content)
+	}))
+	t.Cleanup(repository.server.Close)
+	return repository
+}
+
+func (r *testRepository) keyManager() testKeyManager {
+	publicKey, err := x509.MarshalPKIXPublicKey(&r.key.PublicKey)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return testKeyManager{"repository": publicKey}
+}
+
+func (r *testRepository) config() gomini.RepositoryConfig {
+	return gomini.RepositoryConfig{
+		IndexURL:   r.server.URL + "/index.json",
+		KeyManager: r.keyManager(),
+	}
+}
+
+// addBundle publishes a .tar.gz archive of a bundle reporting its version
+func (r *testRepository) addBundle(id, version string) {
+	archive := tarGz(r.t, map[string]string{
+		"bundle.json": `{"id": "` + id + `", "name": "` + id + `", "version": "` + version + `", "entrypoint": "/index.ts"}`,
+		"index.ts":    reportingIndex(id + "@" + version),
+	})
+	checksum := sha256.Sum256(archive)
+
+	path := "/" + id + "-" + version + ".tar.gz"
+	r.files[path] = archive
+	r.bundles = append(r.bundles, gomini.RepositoryBundle{
+		ID:       id,
+		Version:  version,
+		Checksum: "sha256:" + hex.EncodeToString(checksum[:]),
+		URL:      strings.TrimPrefix(path, "/"),
+	})
+	r.publish()
+}
+
+func (r *testRepository) publish() {
+	index, err := json.Marshal(map[string]interface{}{"bundles": r.bundles})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(index)
+	signature, err := ecdsa.SignASN1(rand.Reader, r.key, digest[:])
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	signatureFile, err := json.Marshal(map[string]string{
+		"fingerprint": "repository",
+		"signature":   base64.StdEncoding.EncodeToString(signature),
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	if r.tampered {
+		index = bytes.Replace(index, []byte(`"bundles"`), []byte(` "bundles"`), 1)
+	}
+	r.files["/index.json"] = index
+	r.files["/index.json.sig"] = signatureFile
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	buffer := &bytes.Buffer{}
+	compressor := gzip.NewWriter(buffer)
+	archive := tar.NewWriter(compressor)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func newRepositoryKernel(t *testing.T, repository *testRepository) *testKernel {
+	client, err := gomini.NewRepository(repository.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := newTestKernel(t, func(config *gomini.KernelConfig) {
+		config.Repository = client
+	})
+	tk.start()
+	return tk
+}
+
+func TestRepositoryVerifiesTheIndexSignature(t *testing.T) {
+	repository := newTestRepository(t)
+	repository.addBundle("app", "1.0.0")
+
+	client, err := gomini.NewRepository(repository.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundles, err := client.Bundles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles) != 1 || bundles[0].ID != "app" {
+		t.Fatalf("unexpected bundles %v", bundles)
+	}
+
+	repository.tampered = true
+	repository.publish()
+	if _, err := client.Bundles(); err == nil || !strings.Contains(err.Error(), "signature is invalid") {
+		t.Fatalf("tampered index was accepted: %v", err)
+	}
+
+	unknownSigner := repository.config()
+	unknownSigner.KeyManager = testKeyManager{}
+	client, err = gomini.NewRepository(unknownSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Bundles(); err == nil {
+		t.Fatal("index of an unknown signer was accepted")
+	}
+}
+
+func TestInstallFromRepository(t *testing.T) {
+	repository := newTestRepository(t)
+	repository.addBundle("app", "1.0.0")
+	repository.addBundle("app", "1.2.0")
+	repository.addBundle("app", "2.0.0")
+	tk := newRepositoryKernel(t, repository)
+
+	bundle, err := tk.InstallFromRepository("app", "^1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, bundle, "1.2.0")
+	if err := tk.StartBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("app@1.2.0")
+
+	if err := tk.UpdateFromRepository("app", "", gomini.BundleUpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("app@2.0.0")
+	expectVersion(t, tk.bundle("app"), "2.0.0")
+
+	if _, err := tk.InstallFromRepository("other", ""); err == nil {
+		t.Fatal("installing a bundle missing in the repository succeeded")
+	}
+}
+
+func TestRepositoryChecksumsAreVerified(t *testing.T) {
+	repository := newTestRepository(t)
+	repository.addBundle("app", "1.0.0")
+	repository.files["/app-1.0.0.tar.gz"] = tarGz(t, map[string]string{
+		"bundle.json": `{"id": "app", "name": "app", "version": "1.0.0", "entrypoint": "/index.ts"}`,
+		"index.ts":    reportingIndex("manipulated"),
+	})
+	tk := newRepositoryKernel(t, repository)
+
+	if _, err := tk.InstallFromRepository("app", ""); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("archive with a wrong checksum was installed: %v", err)
+	}
+	if tk.Bundle("app") != nil {
+		t.Fatal("bundle with a wrong checksum is installed")
+	}
+}
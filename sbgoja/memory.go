@@ -0,0 +1,127 @@
+package sbgoja
+
+import (
+	"reflect"
+	"github.com/dop251/goja"
+	"github.com/go-errors/errors"
+)
+
+// Rough sizes of the engine's internal structures, the estimation only
+// has to be good enough to tell a growing bundle from a steady one
+const (
+	objectOverhead   = 64
+	propertyOverhead = 32
+	valueOverhead    = 16
+)
+
+var (
+	typeArrayBuffer = reflect.TypeOf(goja.ArrayBuffer{})
+	typeProxy       = reflect.TypeOf(goja.Proxy{})
+)
+
+// memoryWalker estimates the size of an object graph. Accessor properties
+// are never invoked and proxies are never entered, as both could run
+// script code or belong to another bundle. Only the engine's public API
+// is used, therefore variables captured by closures are not visible.
+type memoryWalker struct {
+	runtime                  *goja.Runtime
+	getOwnPropertyDescriptor goja.Callable
+	objectConstructor        goja.Value
+	visited                  map[*goja.Object]bool
+	size                     uint64
+}
+
+func prepareMemoryWalker(runtime *goja.Runtime) func() *memoryWalker {
+	// The intrinsics are captured before any bundle code could replace them
+	objectConstructor := runtime.GlobalObject().Get("Object")
+	getOwnPropertyDescriptor, ok := goja.AssertFunction(objectConstructor.(*goja.Object).Get("getOwnPropertyDescriptor"))
+	if !ok {
+		panic("Object.getOwnPropertyDescriptor is not a function")
+	}
+
+	return func() *memoryWalker {
+		return &memoryWalker{
+			runtime:                  runtime,
+			getOwnPropertyDescriptor: getOwnPropertyDescriptor,
+			objectConstructor:        objectConstructor,
+			visited:                  make(map[*goja.Object]bool),
+		}
+	}
+}
+
+func (w *memoryWalker) walk(roots ...*goja.Object) (size uint64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(r)
+		}
+	}()
+
+	pending := roots
+	for len(pending) > 0 {
+		object := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		pending = w.__visitObject(object, pending)
+	}
+	return w.size, nil
+}
+
+func (w *memoryWalker) __visitObject(object *goja.Object, pending []*goja.Object) []*goja.Object {
+	if object == nil || w.visited[object] {
+		return pending
+	}
+	w.visited[object] = true
+	w.size += objectOverhead
+
+	exportType := object.ExportType()
+	switch {
+	case exportType == typeProxy:
+		return pending
+
+	case exportType == typeArrayBuffer:
+		w.size += uint64(len(object.Export().(goja.ArrayBuffer).Bytes()))
+		return pending
+
+	case exportType != nil && exportType.Kind() == reflect.Slice && exportType.Elem().Kind() != reflect.Interface:
+		// Typed arrays and Go slices export as views, not as copies
+		exported := reflect.ValueOf(object.Export())
+		w.size += uint64(exported.Len()) * uint64(exportType.Elem().Size())
+		return pending
+	}
+
+	if prototype := object.Prototype(); prototype != nil {
+		pending = append(pending, prototype)
+	}
+
+	for _, name := range object.GetOwnPropertyNames() {
+		w.size += propertyOverhead + uint64(len(name))
+
+		descriptor, err := w.getOwnPropertyDescriptor(w.objectConstructor, object, w.runtime.ToValue(name))
+		if err != nil {
+			panic(err)
+		}
+		descriptorObject, ok := descriptor.(*goja.Object)
+		if !ok {
+			continue
+		}
+
+		value := descriptorObject.Get("value")
+		if value == nil {
+			// Accessor property
+			continue
+		}
+		pending = w.__visitValue(value, pending)
+	}
+	return pending
+}
+
+func (w *memoryWalker) __visitValue(value goja.Value, pending []*goja.Object) []*goja.Object {
+	switch v := value.(type) {
+	case *goja.Object:
+		return append(pending, v)
+	case goja.String:
+		w.size += valueOverhead + uint64(v.Length())*2
+	default:
+		w.size += valueOverhead
+	}
+	return pending
+}
@@ -111,8 +111,14 @@ func (o *_object) DefineObjectProperty(objectName string, objectBinder gomini.Ob
 
 func (o *_object) DefineAccessorProperty(propertyName string, getter gomini.Getter, setter gomini.Setter) gomini.Object {
 	obj := o.unwrap().(*goja.Object)
-	g := o.sandbox.runtime.ToValue(getter)
-	s := o.sandbox.runtime.ToValue(setter)
+	// Accessors without a setter are read-only
+	var g, s goja.Value
+	if getter != nil {
+		g = o.sandbox.runtime.ToValue(getter)
+	}
+	if setter != nil {
+		s = o.sandbox.runtime.ToValue(setter)
+	}
 	if err := obj.DefineAccessorProperty(propertyName, g, s, goja.FLAG_FALSE, goja.FLAG_TRUE); err != nil {
 		panic(err)
 	}
@@ -121,7 +127,7 @@ func (o *_object) DefineAccessorProperty(propertyName string, getter gomini.Gett
 
 func (o *_object) defineFunction(functionName, propertyName string, function interface{}) gomini.Object {
 	obj := o.unwrap().(*goja.Object)
-	f := o.sandbox.runtime.NewNamedNativeFunction(functionName, function)
+	f := o.sandbox.runtime.NewNamedNativeFunction(functionName, o.sandbox.__throwingFunction(function))
 	if err := obj.DefineDataProperty(propertyName, f, goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_TRUE); err != nil {
 		panic(err)
 	}
@@ -4,9 +4,9 @@ import (
 	"github.com/relationsone/gomini"
 	"github.com/dop251/goja"
 	"reflect"
+	"sync"
 	"github.com/apex/log"
 	"github.com/dop251/goja/parser"
-	"fmt"
 	"github.com/go-errors/errors"
 )
 
@@ -55,6 +55,9 @@ func NewSandbox(bundle gomini.Bundle) gomini.Sandbox {
 
 	sandbox.deepfreeze = prepareDeepFreeze(runtime)
 	sandbox.securityproxy = newSecurityProxy(sandbox)
+	sandbox.newMemoryWalker = prepareMemoryWalker(runtime)
+
+	runtime.SetPromiseRejectionTracker(sandbox.trackPromiseRejection)
 
 	return sandbox
 }
@@ -67,8 +70,14 @@ type sandbox struct {
 	undefined gomini.Value
 	global    gomini.Object
 
-	deepfreeze    func(object *goja.Object)
-	securityproxy *securityProxy
+	deepfreeze      func(object *goja.Object)
+	securityproxy   *securityProxy
+	newMemoryWalker func() *memoryWalker
+
+	throwMutex sync.Mutex
+	throwing   error
+
+	nativeCallHook func()
 }
 
 func (s *sandbox) NewObject() gomini.Object {
@@ -81,7 +90,7 @@ func (s *sandbox) NewObjectCreator(objectName string) gomini.ObjectCreator {
 }
 
 func (s *sandbox) NewNamedNativeFunction(functionName string, function gomini.GoFunction) gomini.Value {
-	return newJsValue(s.runtime.NewNamedNativeFunction(functionName, function), s)
+	return newJsValue(s.runtime.NewNamedNativeFunction(functionName, s.__throwingFunction(function)), s)
 }
 
 func (s *sandbox) NewTypeError(args ...interface{}) gomini.Object {
@@ -92,6 +101,28 @@ func (s *sandbox) NewError(err error) gomini.Object {
 	return newJsObject(s.runtime.NewGoError(err), s)
 }
 
+func (s *sandbox) NewPromise() (gomini.Object, func(value interface{}) error, func(reason interface{}) error) {
+	promise, resolve, reject := s.runtime.NewPromise()
+	return newJsObject(s.runtime.ToValue(promise).(*goja.Object), s), unwrapResolution(resolve), unwrapResolution(reject)
+}
+
+// unwrapResolution settles promises with the script values of wrapped
+// values instead of wrapping the wrappers again
+func unwrapResolution(settle func(interface{}) error) func(interface{}) error {
+	return func(value interface{}) error {
+		if v, ok := value.(gomini.Value); ok {
+			value = v.Unwrap()
+		}
+		return settle(value)
+	}
+}
+
+func (s *sandbox) trackPromiseRejection(promise *goja.Promise, operation goja.PromiseRejectionOperation) {
+	if operation == goja.PromiseRejectionReject {
+		log.Warnf("Sandbox: Possibly unhandled promise rejection in bundle %s: %s", s.bundle.Name(), promise.Result())
+	}
+}
+
 func (s *sandbox) NewModuleProxy(object gomini.Object, objectName string, caller gomini.Bundle) (gomini.Object, error) {
 	proxy, err := s.securityproxy.makeProxy(unwrapGojaObject(object), objectName, s.bundle, caller)
 	if err != nil {
@@ -100,6 +131,10 @@ func (s *sandbox) NewModuleProxy(object gomini.Object, objectName string, caller
 	return newJsObject(proxy, s), nil
 }
 
+func (s *sandbox) RevokeProxies() {
+	s.securityproxy.revokeAll()
+}
+
 func (s *sandbox) IsAccessible(module gomini.Module, caller gomini.Bundle) error {
 	property := module.Name() + ".inject"
 	return sandboxSecurityCheck(property, s.bundle, caller)
@@ -137,11 +172,108 @@ func (s *sandbox) CaptureCallStack(maxStackFrames int) []gomini.StackFrame {
 	return stackFrames
 }
 
+func (s *sandbox) ErrorStackTrace(err error) string {
+	for err != nil {
+		switch e := err.(type) {
+		case *goja.Exception:
+			return e.String()
+		case *errors.Error:
+			err = e.Err
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
 func (s *sandbox) NewDebugger() (interface{}, error) {
 	// TODO
 	return nil, nil
 }
 
+func (s *sandbox) Interrupt(reason error) {
+	s.runtime.Interrupt(reason)
+}
+
+func (s *sandbox) ClearInterrupt() {
+	s.runtime.ClearInterrupt()
+
+	s.throwMutex.Lock()
+	defer s.throwMutex.Unlock()
+	s.throwing = nil
+}
+
+func (s *sandbox) Throw(reason error) {
+	s.throwMutex.Lock()
+	defer s.throwMutex.Unlock()
+	s.throwing = reason
+}
+
+func (s *sandbox) SetNativeCallHook(hook func()) {
+	s.nativeCallHook = hook
+}
+
+// __throwingFunction wraps a native function, so that the native call hook
+// runs and the reason passed to Throw is thrown as soon as the running
+// script calls it
+func (s *sandbox) __throwingFunction(function interface{}) interface{} {
+	if f, ok := function.(func(goja.FunctionCall) goja.Value); ok {
+		return func(call goja.FunctionCall) goja.Value {
+			s.__beforeNativeCall()
+			return f(call)
+		}
+	}
+
+	value := reflect.ValueOf(function)
+	if value.Kind() != reflect.Func {
+		return function
+	}
+	return reflect.MakeFunc(value.Type(), func(arguments []reflect.Value) []reflect.Value {
+		s.__beforeNativeCall()
+		if value.Type().IsVariadic() {
+			return value.CallSlice(arguments)
+		}
+		return value.Call(arguments)
+	}).Interface()
+}
+
+func (s *sandbox) __beforeNativeCall() {
+	if s.nativeCallHook != nil {
+		s.nativeCallHook()
+	}
+	s.__throwPending()
+}
+
+// __throwPending throws the reason passed to Throw as an error object
+// named after the reason's type, e.g. ExecutionLimitError
+func (s *sandbox) __throwPending() {
+	s.throwMutex.Lock()
+	reason := s.throwing
+	s.throwing = nil
+	s.throwMutex.Unlock()
+
+	if reason != nil {
+		exception := s.runtime.NewGoError(reason)
+		exception.Set("name", reflect.Indirect(reflect.ValueOf(reason)).Type().Name())
+		panic(exception)
+	}
+}
+
+func (s *sandbox) MemoryUsage(roots ...gomini.Object) (uint64, error) {
+	objects := make([]*goja.Object, 0, len(roots)+1)
+	objects = append(objects, s.runtime.GlobalObject())
+	for _, root := range roots {
+		objects = append(objects, unwrapGojaObject(root))
+	}
+
+	return s.newMemoryWalker().walk(objects...)
+}
+
+func (s *sandbox) ScriptTarget() string {
+	// Async iteration (es2018) isn't supported by the engine
+	return "es2017"
+}
+
 func (s *sandbox) NullValue() gomini.Value {
 	return s.null
 }
@@ -665,12 +797,8 @@ func freezeObject(value goja.Value, runtime *goja.Runtime) goja.Value {
 }
 
 func sandboxSecurityCheck(property string, origin gomini.Bundle, caller gomini.Bundle) error {
-	interceptor := origin.SecurityInterceptor()
-	if !caller.Privileged() && interceptor != nil {
-		if !interceptor(caller, property) {
-			msg := fmt.Sprintf("illegal access violation: %s cannot access %s::%s", caller.Name(), origin.Name(), property)
-			return errors.New(msg)
-		}
+	if err := gomini.CheckAccess(caller, origin, property); err != nil {
+		return err
 	}
 	log.Debugf("SecurityProxy: SecurityInterceptor check success: %s", property)
 	return nil
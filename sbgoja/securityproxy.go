@@ -1,136 +1,483 @@
 package sbgoja
 
 import (
+	"fmt"
+	"runtime"
+	"sync"
+	"weak"
 	"github.com/dop251/goja"
 	"github.com/relationsone/gomini"
+	"github.com/go-errors/errors"
 )
 
+// membraneMutex guards the proxy registrations of all sandboxes, as
+// revoking a sandbox's proxies touches the registrations of the others
+var membraneMutex sync.Mutex
+
+// securityProxy implements the membrane between the runtime of its
+// sandbox and the runtimes of other bundles. Every object of the sandbox
+// crossing into another runtime is wrapped into a proxy, which checks
+// all accesses against the security policy and wraps everything it hands
+// out again. Wrappers are kept identity-stable per target runtime and
+// are unwrapped when they are passed back into the sandbox.
+//
+// The proxies are built on empty shadow targets of the target runtime,
+// so the proxy invariants never depend on the (possibly frozen) original
+// objects. A membrane proxy therefore reports all properties as
+// configurable but read-only and always stays extensible. Descriptors
+// are taken from the original objects' own properties, accessors are
+// reported with wrapped accessor functions instead of being invoked.
+//
+// Every proxy is registered with the sandboxes of both bundles and is
+// revoked as soon as either of them releases its sandbox. The registrations
+// only reference targets and proxies weakly and are removed as soon as the
+// caller's runtime drops the proxy.
+//
+// Accesses are checked against the property paths the object was reached
+// by, when the access happens. An object reachable by several paths has a
+// single proxy, an access is granted if it is granted for any of the
+// paths the caller reached the object by, so the checks never depend on
+// the path it was reached by first.
+//
+// Runtimes must only be used by the event loop of their bundle, therefore
+// all traps run on the origin's event loop while the caller waits, see
+// gomini.CallBundle. Values are wrapped in both directions on the origin's
+// event loop, as the caller's runtime is held by the waiting call chain.
 type securityProxy struct {
-	sandbox *sandbox
+	sandbox                  *sandbox
+	newShadowFunction        func() *goja.Object
+	getOwnPropertyDescriptor func(object *goja.Object, property string) *goja.Object
+	proxies                  map[proxyKey]*proxyEntry
+	targets                  map[weak.Pointer[goja.Object]]*proxyEntry
+	held                     map[*proxyEntry]bool
+	released                 bool
+}
+
+type proxyKey struct {
+	target  weak.Pointer[goja.Object]
+	runtime *goja.Runtime
+}
+
+// proxyEntry is the registration of a proxy, it must never reference the
+// proxy strongly as the cleanup of the proxy removes the registration
+type proxyEntry struct {
+	key       proxyKey
+	proxy     weak.Pointer[goja.Object]
+	origin    *securityProxy
+	caller    *securityProxy
+	paths     []string
+	revokedBy gomini.Bundle
 }
 
 func newSecurityProxy(sandbox *sandbox) *securityProxy {
 	return &securityProxy{
-		sandbox: sandbox,
+		sandbox:                  sandbox,
+		newShadowFunction:        prepareShadowFunction(sandbox.runtime),
+		getOwnPropertyDescriptor: prepareGetOwnPropertyDescriptor(sandbox.runtime),
+		proxies:                  make(map[proxyKey]*proxyEntry),
+		targets:                  make(map[weak.Pointer[goja.Object]]*proxyEntry),
+		held:                     make(map[*proxyEntry]bool),
+	}
+}
+
+// revokeAll revokes the proxies of the sandbox's objects held by other
+// bundles and the proxies of other bundles' objects held by the sandbox.
+// No new proxies from or into the sandbox are created afterwards.
+func (s *securityProxy) revokeAll() {
+	membraneMutex.Lock()
+	defer membraneMutex.Unlock()
+
+	s.released = true
+	for _, entry := range s.proxies {
+		entry.revoke(s.sandbox.bundle)
+	}
+	for entry := range s.held {
+		entry.revoke(s.sandbox.bundle)
+	}
+}
+
+func (e *proxyEntry) revoke(bundle gomini.Bundle) {
+	e.revokedBy = bundle
+	e.unregister()
+}
+
+// unregister removes the registrations of the entry, a newer entry for the
+// same key, created after the proxy became unreachable, stays registered
+func (e *proxyEntry) unregister() {
+	if e.origin.proxies[e.key] == e {
+		delete(e.origin.proxies, e.key)
+	}
+	delete(e.origin.targets, e.proxy)
+	delete(e.caller.held, e)
+}
+
+// invoke runs the trap on the event loop of the origin, exceptions thrown
+// by the trap are rethrown into the caller's runtime
+func (e *proxyEntry) invoke(callerRuntime *goja.Runtime, trap func()) {
+	e.checkRevoked(callerRuntime)
+	if err := gomini.CallBundle(e.caller.sandbox.bundle, e.origin.sandbox.bundle, trap); err != nil {
+		// The origin's event loop is stopped before its proxies are revoked
+		e.checkRevoked(callerRuntime)
+		panic(callerRuntime.NewGoError(err))
+	}
+}
+
+// reachedBy adds a property path the object was reached by
+func (e *proxyEntry) reachedBy(path string) {
+	for _, p := range e.paths {
+		if p == path {
+			return
+		}
+	}
+	e.paths = append(e.paths, path)
+}
+
+// checkAccess checks the property path, which the given function builds
+// from a path the object was reached by, and returns the first path the
+// access is granted for. Otherwise the denial of the first path is thrown
+// into the caller's runtime.
+func (e *proxyEntry) checkAccess(property func(path string) string, origin, caller gomini.Bundle) string {
+	path, err := e.__grantingPath(property, origin, caller)
+	if err != nil {
+		panic(unwrapGojaRuntime(caller).NewGoError(err))
+	}
+	return path
+}
+
+// granted reports if the property path is granted for any of the paths
+// the object was reached by
+func (e *proxyEntry) granted(property func(path string) string, origin, caller gomini.Bundle) bool {
+	_, err := e.__grantingPath(property, origin, caller)
+	return err == nil
+}
+
+func (e *proxyEntry) __grantingPath(property func(path string) string, origin, caller gomini.Bundle) (string, error) {
+	membraneMutex.Lock()
+	paths := append([]string(nil), e.paths...)
+	membraneMutex.Unlock()
+
+	var denied error
+	for _, path := range paths {
+		err := sandboxSecurityCheck(property(path), origin, caller)
+		if err == nil {
+			return path, nil
+		}
+		if denied == nil {
+			denied = err
+		}
+	}
+	return "", denied
+}
+
+// checkRevoked throws into the caller's runtime if the proxy was revoked
+func (e *proxyEntry) checkRevoked(callerRuntime *goja.Runtime) {
+	membraneMutex.Lock()
+	revokedBy := e.revokedBy
+	membraneMutex.Unlock()
+
+	if revokedBy != nil {
+		err := errors.New(fmt.Sprintf("bundle %s is no longer available", revokedBy.Name()))
+		panic(callerRuntime.NewGoError(err))
+	}
+}
+
+// wrap moves a value of the origin's runtime into the caller's runtime.
+// Primitives are passed as they are, wrappers of the caller's own objects
+// are unwrapped and all other objects are wrapped into a proxy.
+func wrap(value goja.Value, propertyName string, origin, caller gomini.Bundle) goja.Value {
+	object, ok := value.(*goja.Object)
+	if !ok || object == nil {
+		return value
+	}
+	if target, ok := securityProxyOf(caller).unwrap(object); ok {
+		return target
+	}
+	proxy, err := securityProxyOf(origin).makeProxy(object, propertyName, origin, caller)
+	if err != nil {
+		panic(err)
+	}
+	return proxy
+}
+
+func securityProxyOf(bundle gomini.Bundle) *securityProxy {
+	return bundle.Sandbox().(*sandbox).securityproxy
+}
+
+func (s *securityProxy) unwrap(proxy *goja.Object) (*goja.Object, bool) {
+	membraneMutex.Lock()
+	defer membraneMutex.Unlock()
+
+	if entry, ok := s.targets[weak.Make(proxy)]; ok {
+		if target := entry.key.target.Value(); target != nil {
+			return target, true
+		}
 	}
+	return nil, false
 }
 
+// makeProxy returns the proxy of an object of the origin's runtime for
+// the caller's runtime. The property name is the path the object was
+// reached by and prefixes the property paths checked against the security
+// policy, it is added to the paths of an existing proxy. Objects handed
+// from the caller to the origin, like callbacks, are reached by "this"
+// and "arguments".
 func (s *securityProxy) makeProxy(target *goja.Object, propertyName string, origin, caller gomini.Bundle) (*goja.Object, error) {
-	originRuntime := unwrapGojaRuntime(origin)
+	// The origin may have released its sandbox since the module proxy was
+	// requested, which is reported below
+	originRuntime := s.sandbox.runtime
 	callerRuntime := unwrapGojaRuntime(caller)
 
+	// The lookup and the registration of a new proxy must not interleave
+	// with other lookups or with the revocation of either sandbox
+	membraneMutex.Lock()
+	defer membraneMutex.Unlock()
+
+	entry := &proxyEntry{
+		key:    proxyKey{weak.Make(target), callerRuntime},
+		origin: s,
+		caller: securityProxyOf(caller),
+	}
+	for _, released := range []*securityProxy{entry.origin, entry.caller} {
+		if released.released {
+			return nil, errors.New(fmt.Sprintf("bundle %s is no longer available", released.sandbox.bundle.Name()))
+		}
+	}
+
+	if existing, ok := s.proxies[entry.key]; ok {
+		if proxy := existing.proxy.Value(); proxy != nil {
+			existing.reachedBy(propertyName)
+			return proxy, nil
+		}
+	}
+	entry.reachedBy(propertyName)
+
+	_, callable := goja.AssertFunction(target)
+
 	handler := &goja.ProxyTrapConfig{
-		GetPrototypeOf: func(target *goja.Object) *goja.Object {
-			return callerRuntime.NewTypeError("Proxies have no prototypes")
+		GetPrototypeOf: func(shadow *goja.Object) *goja.Object {
+			// Prototypes are part of the origin's runtime
+			return nil
 		},
-		IsExtensible: func(target *goja.Object) bool {
-			return false
+		IsExtensible: func(shadow *goja.Object) bool {
+			return true
 		},
-		DefineProperty: func(target *goja.Object, key string, propertyDescriptor goja.PropertyDescriptor) bool {
+		DefineProperty: func(shadow *goja.Object, key string, propertyDescriptor goja.PropertyDescriptor) bool {
 			return false
 		},
-		DeleteProperty: func(target *goja.Object, property string) bool {
+		DeleteProperty: func(shadow *goja.Object, property string) bool {
 			return false
 		},
-		PreventExtensions: func(target *goja.Object) bool {
-			return true
-		},
-		Set: func(target *goja.Object, property string, value goja.Value, receiver *goja.Object) bool {
+		PreventExtensions: func(shadow *goja.Object) bool {
 			return false
 		},
-		GetOwnPropertyDescriptor: func(target *goja.Object, prop string) goja.PropertyDescriptor {
-			return goja.PropertyDescriptor{
-				Writable:     goja.FLAG_FALSE,
-				Configurable: goja.FLAG_FALSE,
-				Enumerable:   goja.FLAG_TRUE,
-				Value:        nil,
-				Setter:       nil,
-				Getter: callerRuntime.ToValue(func() goja.Value {
-					// TODO
-					return nil
-				}),
-			}
+		Set: func(shadow *goja.Object, property string, value goja.Value, receiver *goja.Object) bool {
+			return false
 		},
-		Get: func(target *goja.Object, property string, receiver *goja.Object) goja.Value {
-			s.accessCheck(propertyName+"."+property+".get", origin, caller)
+		GetOwnPropertyDescriptor: func(shadow *goja.Object, property string) (descriptor goja.PropertyDescriptor) {
+			entry.invoke(callerRuntime, func() {
+				path := entry.checkAccess(getProperty(property), origin, caller)
 
-			source := target.Get(property)
-			if o, ok := source.(*goja.Object); ok {
-				proxy, err := s.makeProxy(o, propertyName+"."+property, origin, caller)
-				if err != nil {
-					panic(err)
+				own := s.getOwnPropertyDescriptor(target, property)
+				if own == nil {
+					return
 				}
-				return proxy
-			}
-			return source
+				descriptor = goja.PropertyDescriptor{
+					Configurable: goja.FLAG_TRUE,
+					Enumerable:   flagOf(own.Get("enumerable")),
+				}
+				getter, setter := own.Get("get"), own.Get("set")
+				if getter == nil && setter == nil {
+					descriptor.Value = wrap(own.Get("value"), path+"."+property, origin, caller)
+					descriptor.Writable = goja.FLAG_FALSE
+					return
+				}
+				descriptor.Getter = wrap(getter, path+"."+property+".get", origin, caller)
+				descriptor.Setter = wrap(setter, path+"."+property+".set", origin, caller)
+			})
+			return descriptor
 		},
-		Has: func(target *goja.Object, property string) bool {
-			s.accessCheck(propertyName+"."+property+".has", origin, caller)
+		Get: func(shadow *goja.Object, property string, receiver *goja.Object) (value goja.Value) {
+			entry.invoke(callerRuntime, func() {
+				path := entry.checkAccess(getProperty(property), origin, caller)
 
-			return target.Get(property) != nil
+				value = wrap(target.Get(property), path+"."+property, origin, caller)
+			})
+			return value
 		},
-		OwnKeys: func(target *goja.Object) *goja.Object {
-			return callerRuntime.ToValue(target.Keys()).(*goja.Object)
+		Has: func(shadow *goja.Object, property string) (has bool) {
+			entry.invoke(callerRuntime, func() {
+				entry.checkAccess(func(path string) string {
+					return path + "." + property + ".has"
+				}, origin, caller)
+
+				has = target.Get(property) != nil
+			})
+			return has
 		},
-		Apply: func(target *goja.Object, this *goja.Object, argumentsList []goja.Value) goja.Value {
-			s.accessCheck(propertyName+".apply", origin, caller)
-
-			thisProxy, err := s.makeProxy(this, propertyName+".this", caller, origin)
-			if err != nil {
-				panic(err)
-			}
-
-			var function func(goja.FunctionCall) goja.Value
-			err = originRuntime.ExportTo(target, &function)
-			if err != nil {
-				panic(err)
-			}
-
-			arguments := make([]goja.Value, len(argumentsList))
-			for i, arg := range argumentsList {
-				if a, ok := arg.(*goja.Object); ok {
-					arg, err = s.makeProxy(a, "", caller, origin)
-					if err != nil {
-						panic(err)
+		OwnKeys: func(shadow *goja.Object) (keys *goja.Object) {
+			entry.invoke(callerRuntime, func() {
+				// Only properties the caller may get are enumerated
+				granted := make([]interface{}, 0)
+				for _, key := range target.Keys() {
+					if entry.granted(getProperty(key), origin, caller) {
+						granted = append(granted, key)
 					}
 				}
-				arguments[i] = arg
-			}
-
-			ret := function(goja.FunctionCall{
-				This:      thisProxy,
-				Arguments: argumentsList, // TODO adapt arguments
+				keys = callerRuntime.NewArray(granted...)
 			})
+			return keys
+		},
+		Apply: func(shadow *goja.Object, this *goja.Object, argumentsList []goja.Value) (value goja.Value) {
+			entry.invoke(callerRuntime, func() {
+				path := entry.checkAccess(func(path string) string {
+					return callProperty(path+".apply", argumentsList)
+				}, origin, caller)
+
+				function, ok := goja.AssertFunction(target)
+				if !ok {
+					panic(callerRuntime.NewTypeError("%s is not a function", path))
+				}
 
-			return ret // TODO adapt
+				var originThis goja.Value = goja.Undefined()
+				if this != nil {
+					originThis = wrap(this, "this", caller, origin)
+				}
+				ret, err := function(originThis, wrapArguments(argumentsList, caller, origin)...)
+				if err != nil {
+					throwInto(err, origin, caller)
+				}
+				value = wrap(ret, path+".return", origin, caller)
+			})
+			return value
 		},
-		Construct: func(target *goja.Object, argumentsList []goja.Value, newTarget *goja.Object) *goja.Object {
-			var constructor func(call goja.ConstructorCall) *goja.Object
-			err := originRuntime.ExportTo(target, &constructor)
-			if err != nil {
-				panic(err)
-			}
-
-			ret := constructor(goja.ConstructorCall{
-				This:      target,
-				Arguments: argumentsList, // TODO adapt arguments
+		Construct: func(shadow *goja.Object, argumentsList []goja.Value, newTarget *goja.Object) (object *goja.Object) {
+			entry.invoke(callerRuntime, func() {
+				path := entry.checkAccess(func(path string) string {
+					return callProperty(path+".construct", argumentsList)
+				}, origin, caller)
+
+				ret, err := originRuntime.New(target, wrapArguments(argumentsList, caller, origin)...)
+				if err != nil {
+					throwInto(err, origin, caller)
+				}
+				object = wrap(ret, path+".constructor", origin, caller).(*goja.Object)
 			})
+			return object
+		},
+	}
 
-			proxy, err := s.makeProxy(ret, propertyName+".constructor", origin, caller)
-			if err != nil {
-				panic(err)
-			}
+	shadow := callerRuntime.NewObject()
+	if callable {
+		shadow = s.__shadowFunction(caller)
+	}
 
-			return proxy
-		},
+	proxy := callerRuntime.ToValue(callerRuntime.NewProxy(shadow, handler, false, false)).(*goja.Object)
+	entry.proxy = weak.Make(proxy)
+	runtime.AddCleanup(proxy, func(entry *proxyEntry) {
+		membraneMutex.Lock()
+		defer membraneMutex.Unlock()
+		entry.unregister()
+	}, entry)
+
+	s.proxies[entry.key] = entry
+	s.targets[entry.proxy] = entry
+	entry.caller.held[entry] = true
+	return proxy, nil
+}
+
+// __shadowFunction creates a callable shadow target in the caller's
+// runtime. Bound functions are constructible but, unlike plain
+// functions, have no non-configurable prototype property.
+func (s *securityProxy) __shadowFunction(caller gomini.Bundle) *goja.Object {
+	return securityProxyOf(caller).newShadowFunction()
+}
+
+func wrapArguments(argumentsList []goja.Value, from, to gomini.Bundle) []goja.Value {
+	arguments := make([]goja.Value, len(argumentsList))
+	for i, argument := range argumentsList {
+		arguments[i] = wrap(argument, "arguments", from, to)
+	}
+	return arguments
+}
+
+// throwInto rethrows an error of a call into the origin's runtime as an
+// exception of the caller's runtime. Interruptions are passed through.
+func throwInto(err error, origin, caller gomini.Bundle) {
+	if exception, ok := err.(*goja.Exception); ok {
+		panic(wrap(exception.Value(), "exception", origin, caller))
+	}
+	panic(err)
+}
+
+// callProperty appends the resource of a call to the property path, which
+// is the first argument if it is a string
+func callProperty(property string, arguments []goja.Value) string {
+	if len(arguments) > 0 && arguments[0] != nil {
+		if resource, ok := arguments[0].Export().(string); ok {
+			return property + ":" + resource
+		}
 	}
+	return property
+}
 
-	proxy := callerRuntime.NewProxy(target, handler, false, false)
-	return callerRuntime.ToValue(proxy).(*goja.Object), nil
+// getProperty builds the property path of reading a property
+func getProperty(property string) func(path string) string {
+	return func(path string) string {
+		return path + "." + property + ".get"
+	}
 }
 
-func (s *securityProxy) accessCheck(propertyName string, origin, caller gomini.Bundle) {
-	if err := sandboxSecurityCheck(propertyName, origin, caller); err != nil {
+func flagOf(value goja.Value) goja.Flag {
+	if value != nil && value.ToBoolean() {
+		return goja.FLAG_TRUE
+	}
+	return goja.FLAG_FALSE
+}
+
+// prepareGetOwnPropertyDescriptor captures Object.getOwnPropertyDescriptor
+// before any bundle code could replace it. The returned function returns
+// nil if the object has no such own property.
+func prepareGetOwnPropertyDescriptor(runtime *goja.Runtime) func(object *goja.Object, property string) *goja.Object {
+	objectConstructor := runtime.GlobalObject().Get("Object")
+	getOwnPropertyDescriptor, ok := goja.AssertFunction(objectConstructor.(*goja.Object).Get("getOwnPropertyDescriptor"))
+	if !ok {
+		panic("Object.getOwnPropertyDescriptor is not a function")
+	}
+
+	return func(object *goja.Object, property string) *goja.Object {
+		descriptor, err := getOwnPropertyDescriptor(objectConstructor, object, runtime.ToValue(property))
+		if err != nil {
+			panic(err)
+		}
+		if descriptorObject, ok := descriptor.(*goja.Object); ok {
+			return descriptorObject
+		}
+		return nil
+	}
+}
+
+func prepareShadowFunction(runtime *goja.Runtime) func() *goja.Object {
+	source := `
+	(function () {
+		return function () {
+			return function () {}.bind(null);
+		};
+	})();
+	`
+
+	value, err := runtime.RunScript("system::ShadowFunction", source)
+	if err != nil {
 		panic(err)
 	}
+	factory, ok := goja.AssertFunction(value)
+	if !ok {
+		panic("shadow function factory is not a function")
+	}
+	return func() *goja.Object {
+		shadow, err := factory(goja.Undefined())
+		if err != nil {
+			panic(err)
+		}
+		return shadow.(*goja.Object)
+	}
 }
@@ -0,0 +1,227 @@
+package gomini_test
+
+import (
+	"strings"
+	"testing"
+	"github.com/relationsone/gomini"
+)
+
+const libraryApi = `
+	System.register([], function (exports_1) {
+		var state = {count: 0};
+		Object.defineProperty(state, "watched", {
+			get: function () {
+				report("invoked");
+				return state.count;
+			},
+			enumerable: true
+		});
+		return {
+			setters: [],
+			execute: function () {
+				exports_1("state", function () {
+					return state;
+				});
+				exports_1("shared", state);
+				exports_1("alias", state);
+				exports_1("echo", function (value) {
+					return value;
+				});
+				exports_1("increment", function (callback) {
+					state.count++;
+					return callback(state.count);
+				});
+			}
+		};
+	});
+`
+
+const libraryIndex = `
+	System.register([], function (exports_1) {
+		return {
+			setters: [],
+			execute: function () {
+				setInterval(function () {}, 1);
+			}
+		};
+	});
+`
+
+func writeLibrary(tk *testKernel) {
+	tk.writeBundle("lib", map[string]interface{}{
+		"name":    "com.acme.lib",
+		"exports": map[string]string{"api": "/api.ts"},
+	}, map[string]string{
+		"index.ts": libraryIndex,
+		"api.ts":   libraryApi,
+	})
+}
+
+func TestPermissionsRestrictAccessToExports(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	writeLibrary(tk)
+	tk.writeBundle("app", map[string]interface{}{
+		"requires":    []map[string]string{{"id": "lib"}},
+		"permissions": []string{"bundle:com.acme.lib/api.state"},
+	}, map[string]string{
+		"index.ts": `
+			System.register(["bundle:com.acme.lib/api"], function (exports_1) {
+				var api;
+				return {
+					setters: [function (m) { api = m; }],
+					execute: function () {
+						report(api.state().count);
+						try {
+							api.increment(function () {});
+							report("granted");
+						} catch (e) {
+							report("denied");
+						}
+					}
+				};
+			});
+		`,
+	})
+	tk.writeBundle("enumerating", map[string]interface{}{
+		"requires":    []map[string]string{{"id": "lib"}},
+		"permissions": []string{"bundle:com.acme.lib/api.state", "bundle:com.acme.lib/api.shared"},
+	}, map[string]string{
+		"index.ts": `
+			System.register(["bundle:com.acme.lib/api"], function (exports_1) {
+				var api;
+				return {
+					setters: [function (m) { api = m; }],
+					execute: function () {
+						// Properties the bundle may not read are not enumerated
+						report(Object.keys(api).sort().join(","));
+					}
+				};
+			});
+		`,
+	})
+	tk.writeBundle("other", map[string]interface{}{
+		"requires": []map[string]string{{"id": "lib"}},
+	}, map[string]string{
+		"index.ts": `
+			System.register(["bundle:com.acme.lib/api"], function (exports_1) {
+				return {
+					setters: [function (m) {}],
+					execute: function () {
+						report("imported");
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+
+	reported := map[string][]interface{}{}
+	for i := 0; i < 3; i++ {
+		report := tk.nextReport()
+		reported[report.bundleId] = append(reported[report.bundleId], report.value)
+	}
+	if values := reported["app"]; len(values) != 2 || !sameValue(values[0], 0) || values[1] != "denied" {
+		t.Fatalf("unexpected accesses of the app %v", values)
+	}
+	if keys := reported["enumerating"]; len(keys) != 1 || keys[0] != "shared,state" {
+		t.Fatalf("unexpected enumerated keys %v", keys)
+	}
+	// Importing requires a permission for the module
+	tk.awaitStatus("other", gomini.BundleStatusFailed)
+}
+
+func TestMembraneCallsIntoOtherBundles(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	writeLibrary(tk)
+	tk.writeBundle("app", map[string]interface{}{
+		"requires":    []map[string]string{{"id": "lib"}},
+		"permissions": []string{"bundle:com.acme.lib/api"},
+	}, map[string]string{
+		"index.ts": `
+			System.register(["bundle:com.acme.lib/api"], function (exports_1) {
+				var api;
+				return {
+					setters: [function (m) { api = m; }],
+					execute: function () {
+						report(api.state() === api.state());
+						var callback = function (count) { return count * 10; };
+						report(api.increment(callback));
+						report(api.state().count);
+						// Objects returning to their origin are unwrapped
+						var own = {};
+						report(api.echo(own) === own);
+						report(api.echo(callback) === callback);
+						report(Object.getOwnPropertyDescriptor(api.state(), "count").value);
+						// Objects reached by several paths have a single proxy
+						report(api.shared === api.alias && api.shared === api.state());
+						// Descriptors are the original's own ones, accessors
+						// aren't invoked and inherited properties aren't own
+						var descriptor = Object.getOwnPropertyDescriptor(api.state(), "watched");
+						report(typeof descriptor.get + " " + descriptor.value);
+						report(Object.getOwnPropertyDescriptor(api.state(), "toString"));
+						report(api.state().watched);
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+
+	tk.expectReports(true, 10, 1, true, true, 1, true, "function undefined", nil, "invoked", 1)
+}
+
+func TestProxiesAreRevokedWhenABundleStops(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("events", map[string]interface{}{
+		"name":    "com.acme.events",
+		"exports": map[string]string{"api": "/api.ts"},
+	}, map[string]string{
+		"index.ts": libraryIndex,
+		"api.ts": `
+			System.register([], function (exports_1) {
+				return {
+					setters: [],
+					execute: function () {
+						exports_1("subscribe", function (callback) {
+							var interval = setInterval(function () {
+								try {
+									callback();
+								} catch (e) {
+									clearInterval(interval);
+									report(e.message);
+								}
+							}, 5);
+						});
+					}
+				};
+			});
+		`,
+	})
+	tk.writeBundle("app", map[string]interface{}{
+		"requires":    []map[string]string{{"id": "events"}},
+		"permissions": []string{"bundle:com.acme.events/api"},
+	}, map[string]string{
+		"index.ts": `
+			System.register(["bundle:com.acme.events/api"], function (exports_1) {
+				var api;
+				return {
+					setters: [function (m) { api = m; }],
+					execute: function () {
+						api.subscribe(function () {});
+						report("subscribed");
+					}
+				};
+			});
+		`,
+	})
+	tk.start()
+	tk.expectReports("subscribed")
+
+	if err := tk.StopBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	report := tk.nextReport()
+	if message, _ := report.value.(string); report.bundleId != "events" || !strings.Contains(message, "no longer available") {
+		t.Fatalf("unexpected report %v of %s after the subscriber stopped", report.value, report.bundleId)
+	}
+}
@@ -0,0 +1,238 @@
+package gomini
+
+import (
+	"sync"
+	"time"
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/apex/log"
+)
+
+const (
+	restartPolicyNever     = "never"
+	restartPolicyOnFailure = "on-failure"
+	restartPolicyAlways    = "always"
+
+	defaultMaxRestarts    = 5
+	defaultRestartWindow  = 10 * time.Minute
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+)
+
+// restartConfig is the "restart" section of the bundle.json. Durations
+// are given in the notation of time.ParseDuration, e.g. "1m30s".
+type restartConfig struct {
+	Policy         string `json:"policy"`
+	MaxRestarts    int    `json:"max_restarts"`
+	Window         string `json:"window"`
+	InitialBackoff string `json:"initial_backoff"`
+	MaxBackoff     string `json:"max_backoff"`
+}
+
+// restartPolicy is the parsed restartConfig of a bundle.
+//
+// With "on-failure" a failed bundle is restarted with exponential backoff
+// until it failed more than maxRestarts times inside the window, then it
+// stays FAILED. With "always" the bundle is never given up but restarted
+// with the maximum backoff after exceeding maxRestarts.
+type restartPolicy struct {
+	policy         string
+	maxRestarts    int
+	window         time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func newRestartPolicy(config *restartConfig) (*restartPolicy, error) {
+	policy := &restartPolicy{
+		policy:         restartPolicyNever,
+		maxRestarts:    defaultMaxRestarts,
+		window:         defaultRestartWindow,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+	}
+
+	if config == nil {
+		return policy, nil
+	}
+
+	switch config.Policy {
+	case "":
+	case restartPolicyNever, restartPolicyOnFailure, restartPolicyAlways:
+		policy.policy = config.Policy
+	default:
+		return nil, errors.New(fmt.Sprintf("illegal restart policy: %s", config.Policy))
+	}
+
+	if config.MaxRestarts < 0 {
+		return nil, errors.New("max_restarts must not be negative")
+	}
+	if config.MaxRestarts > 0 {
+		policy.maxRestarts = config.MaxRestarts
+	}
+
+	var err error
+	if policy.window, err = parseDurationOrDefault(config.Window, policy.window); err != nil {
+		return nil, err
+	}
+	if policy.initialBackoff, err = parseDurationOrDefault(config.InitialBackoff, policy.initialBackoff); err != nil {
+		return nil, err
+	}
+	if policy.maxBackoff, err = parseDurationOrDefault(config.MaxBackoff, policy.maxBackoff); err != nil {
+		return nil, err
+	}
+	if policy.maxBackoff < policy.initialBackoff {
+		policy.maxBackoff = policy.initialBackoff
+	}
+
+	return policy, nil
+}
+
+func parseDurationOrDefault(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New(err)
+	}
+	if duration < 0 {
+		return 0, errors.New(fmt.Sprintf("illegal negative duration: %s", value))
+	}
+	return duration, nil
+}
+
+type supervisedBundle struct {
+	restarts []time.Time
+	timer    *time.Timer
+}
+
+// bundleSupervisor watches bundle status transitions and restarts failed
+// bundles according to their restart policy.
+type bundleSupervisor struct {
+	bundleManager *bundleManager
+	mutex         sync.Mutex
+	bundles       map[string]*supervisedBundle
+	stopped       bool
+}
+
+func newBundleSupervisor(bundleManager *bundleManager) *bundleSupervisor {
+	return &bundleSupervisor{
+		bundleManager: bundleManager,
+		bundles:       make(map[string]*supervisedBundle),
+	}
+}
+
+func (s *bundleSupervisor) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stopped = true
+	for _, supervised := range s.bundles {
+		if supervised.timer != nil {
+			supervised.timer.Stop()
+			supervised.timer = nil
+		}
+	}
+}
+
+func (s *bundleSupervisor) onStatusChange(event BundleStatusEvent) {
+	b, ok := event.Bundle.(*bundle)
+	if !ok || b.config == nil || b.restartPolicy == nil {
+		return
+	}
+
+	switch event.NewStatus {
+	case BundleStatusFailed:
+		s.scheduleRestart(b)
+	case BundleStatusStopping, BundleStatusStopped:
+		// Explicitly stopped bundles are not restarted anymore
+		s.cancelRestart(b)
+	}
+}
+
+func (s *bundleSupervisor) scheduleRestart(bundle *bundle) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	policy := bundle.restartPolicy
+	if s.stopped || policy.policy == restartPolicyNever {
+		return
+	}
+
+	supervised := s.bundles[bundle.ID()]
+	if supervised == nil {
+		supervised = &supervisedBundle{}
+		s.bundles[bundle.ID()] = supervised
+	}
+
+	if supervised.timer != nil {
+		supervised.timer.Stop()
+	}
+
+	// Forget restarts which happened outside of the window
+	now := time.Now()
+	restarts := make([]time.Time, 0, len(supervised.restarts))
+	for _, restart := range supervised.restarts {
+		if now.Sub(restart) < policy.window {
+			restarts = append(restarts, restart)
+		}
+	}
+	supervised.restarts = restarts
+
+	var backoff time.Duration
+	if len(restarts) >= policy.maxRestarts {
+		if policy.policy != restartPolicyAlways {
+			log.Errorf("Supervisor: Bundle %s failed %d times within %s, giving up",
+				bundle.Name(), len(restarts), policy.window)
+			return
+		}
+		backoff = policy.maxBackoff
+	} else {
+		backoff = policy.initialBackoff << uint(len(restarts))
+		if backoff > policy.maxBackoff || backoff <= 0 {
+			backoff = policy.maxBackoff
+		}
+	}
+
+	supervised.restarts = append(supervised.restarts, now)
+
+	log.Infof("Supervisor: Restarting bundle %s in %s", bundle.Name(), backoff)
+	id := bundle.ID()
+	supervised.timer = time.AfterFunc(backoff, func() {
+		s.__restart(id)
+	})
+}
+
+func (s *bundleSupervisor) cancelRestart(bundle *bundle) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if supervised := s.bundles[bundle.ID()]; supervised != nil {
+		if supervised.timer != nil {
+			supervised.timer.Stop()
+		}
+		delete(s.bundles, bundle.ID())
+	}
+}
+
+func (s *bundleSupervisor) __restart(id string) {
+	s.mutex.Lock()
+	stopped := s.stopped
+	s.mutex.Unlock()
+
+	if stopped {
+		return
+	}
+
+	err := s.bundleManager.withBundle(id, func(bundle *bundle) error {
+		// Somebody else might have taken care of the bundle in the meantime
+		if bundle.Status() != BundleStatusFailed {
+			return nil
+		}
+		return s.bundleManager.startBundle(bundle)
+	})
+	if err != nil {
+		log.Warnf("Supervisor: Restarting bundle %s failed: %s", id, err.Error())
+	}
+}
@@ -0,0 +1,57 @@
+package gomini_test
+
+import (
+	"testing"
+	"time"
+	"github.com/relationsone/gomini"
+)
+
+func TestSupervisorRestartsWithBackoff(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", map[string]interface{}{
+		"restart": map[string]interface{}{
+			"policy":          "on-failure",
+			"max_restarts":    2,
+			"initial_backoff": "100ms",
+			"max_backoff":     "1s",
+		},
+	}, map[string]string{"index.ts": crashingIndex})
+	tk.start()
+
+	tk.expectReports("started")
+	starts := []time.Time{time.Now()}
+	for i := 0; i < 2; i++ {
+		tk.expectReports("started")
+		starts = append(starts, time.Now())
+	}
+
+	// The backoff doubles with every restart inside of the window
+	if first := starts[1].Sub(starts[0]); first < 100*time.Millisecond {
+		t.Fatalf("first restart after %s, expected a backoff of 100ms", first)
+	}
+	if second := starts[2].Sub(starts[1]); second < 200*time.Millisecond {
+		t.Fatalf("second restart after %s, expected a backoff of 200ms", second)
+	}
+
+	// The supervisor gives up after max_restarts
+	tk.awaitStatus("app", gomini.BundleStatusFailed)
+	tk.expectNoReport(500 * time.Millisecond)
+	if tk.bundle("app").Status() != gomini.BundleStatusFailed {
+		t.Fatalf("bundle is %s after the supervisor gave up", tk.bundle("app").Status())
+	}
+}
+
+func TestSupervisorDoesNotRestartStoppedBundles(t *testing.T) {
+	tk := newTestKernel(t, nil)
+	tk.writeBundle("app", map[string]interface{}{
+		"restart": map[string]interface{}{"policy": "always", "initial_backoff": "10ms"},
+	}, map[string]string{"index.ts": lifecycleIndex})
+	tk.start()
+	tk.expectReports("started")
+
+	if err := tk.StopBundle("app"); err != nil {
+		t.Fatal(err)
+	}
+	tk.expectReports("stopped")
+	tk.expectNoReport(100 * time.Millisecond)
+}
@@ -0,0 +1,114 @@
+package gomini
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"github.com/efarrer/iothrottler"
+	"github.com/spf13/afero"
+)
+
+// throttledFs passes all reads and writes of the opened files through the
+// bandwidth of an I/O throttler pool. Metadata operations are not throttled.
+// The time spent waiting for the bandwidth doesn't count against the
+// execution limits of the bundle.
+type throttledFs struct {
+	afero.Fs
+	pool   *iothrottler.IOThrottlerPool
+	bundle *bundle
+}
+
+func newThrottledFs(base afero.Fs, pool *iothrottler.IOThrottlerPool, bundle *bundle) afero.Fs {
+	return &throttledFs{
+		Fs:     base,
+		pool:   pool,
+		bundle: bundle,
+	}
+}
+
+func (t *throttledFs) Name() string {
+	return "ThrottledFs"
+}
+
+func (t *throttledFs) Create(name string) (afero.File, error) {
+	return t.__wrap(t.Fs.Create(name))
+}
+
+func (t *throttledFs) Open(name string) (afero.File, error) {
+	return t.__wrap(t.Fs.Open(name))
+}
+
+func (t *throttledFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return t.__wrap(t.Fs.OpenFile(name, flag, perm))
+}
+
+func (t *throttledFs) __wrap(file afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &throttledFile{
+		File:   file,
+		pool:   t.pool,
+		bundle: t.bundle,
+	}, nil
+}
+
+type throttledFile struct {
+	afero.File
+	pool   *iothrottler.IOThrottlerPool
+	bundle *bundle
+}
+
+func (f *throttledFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if throttleErr := f.__consume(p[:n]); throttleErr != nil {
+		return n, throttleErr
+	}
+	return n, err
+}
+
+func (f *throttledFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	if throttleErr := f.__consume(p[:n]); throttleErr != nil {
+		return n, throttleErr
+	}
+	return n, err
+}
+
+func (f *throttledFile) Write(p []byte) (int, error) {
+	if err := f.__consume(p); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *throttledFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.__consume(p); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *throttledFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// __consume blocks until the pool granted the bandwidth to transfer the
+// given bytes, by reading them through a throttled reader of the pool
+func (f *throttledFile) __consume(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	return f.bundle.waitUnaccounted(func() error {
+		reader, err := f.pool.AddReader(ioutil.NopCloser(bytes.NewReader(p)))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		_, err = io.Copy(ioutil.Discard, reader)
+		return err
+	})
+}
@@ -1,16 +1,22 @@
 package gomini
 
 import (
+	"fmt"
+	"strings"
 	"path/filepath"
 	"os"
 	"encoding/json"
 	"runtime"
 	"github.com/spf13/afero"
 	"github.com/apex/log"
+	"github.com/go-errors/errors"
 )
 
 const cacheJsonFile = "cache.json"
 
+// scriptTargets lists the supported TypeScript targets, oldest first
+var scriptTargets = []string{"es3", "es5", "es2015", "es2016", "es2017", "es2018", "es2019", "es2020", "esnext"}
+
 type transpiler struct {
 	sandbox           Sandbox
 	kernel            *kernel
@@ -60,7 +66,7 @@ func (t *transpiler) transpileFile(bundle Bundle, path string) (*string, error)
 	code := string(data)
 
 	checksum := hash(code)
-	module := t.__findTranspiledModule(path)
+	module := t.__findTranspiledModule(cacheFile)
 
 	isCached := fileExists(t.kernel.Filesystem(), cacheFile)
 	if isCached && module != nil && module.Checksum == checksum {
@@ -90,7 +96,7 @@ func (t *transpiler) transpileFile(bundle Bundle, path string) (*string, error)
 
 	log.Infof("Transpiler: Transpiling '%s:/%s' to 'kernel:/%s'...", bundle.Name(), path, cacheFile)
 
-	if source, err := t.__transpileSource(code); err != nil {
+	if source, err := t.__transpileSource(code, t.kernel.transpilerTarget(bundle)); err != nil {
 		return nil, err
 
 	} else {
@@ -136,3 +142,47 @@ func (t *transpiler) transpileAll(bundle Bundle, root string) error {
 
 	return nil
 }
+
+// transpilerTarget returns the target TypeScript files of the given bundle
+// are transpiled to. Bundles may define their own target, otherwise the
+// kernel's target or the newest target supported by the sandbox is used.
+func (k *kernel) transpilerTarget(loader Bundle) string {
+	if b, ok := loader.(*bundle); ok && b.config != nil && b.config.Target != "" {
+		return b.config.Target
+	}
+	if k.kernelConfig.TranspilerTarget != "" {
+		return k.kernelConfig.TranspilerTarget
+	}
+	return k.sandbox.ScriptTarget()
+}
+
+// checkScriptTarget normalizes the target and verifies it is known and
+// natively supported by the sandbox.
+func checkScriptTarget(target, supported string) (string, error) {
+	target = normalizeScriptTarget(target)
+	index := scriptTargetIndex(target)
+	if index == -1 {
+		return "", errors.New(fmt.Sprintf("unknown transpiler target: %s", target))
+	}
+	if index > scriptTargetIndex(normalizeScriptTarget(supported)) {
+		return "", errors.New(fmt.Sprintf("transpiler target %s is not supported by the sandbox (newest: %s)", target, supported))
+	}
+	return target, nil
+}
+
+func normalizeScriptTarget(target string) string {
+	target = strings.ToLower(target)
+	if target == "es6" {
+		return "es2015"
+	}
+	return target
+}
+
+func scriptTargetIndex(target string) int {
+	for i, candidate := range scriptTargets {
+		if candidate == target {
+			return i
+		}
+	}
+	return -1
+}
@@ -52,7 +52,7 @@ func (t *transpiler) __initialize() {
 	}
 }
 
-func (t *transpiler) __transpileSource(source string) (*string, error) {
+func (t *transpiler) __transpileSource(source, target string) (*string, error) {
 	// Make sure the underlying runtime is initialized
 	t.__initialize()
 
@@ -68,7 +68,7 @@ func (t *transpiler) __transpileSource(source string) (*string, error) {
 	}
 
 	// Transpile
-	if val, err := transpiler(jsTranspiler, t.sandbox.ToValue(source)); err != nil {
+	if val, err := transpiler(jsTranspiler, t.sandbox.ToValue(source), t.sandbox.ToValue(target)); err != nil {
 		return nil, err
 	} else {
 		source := val.String()
@@ -98,9 +98,9 @@ func (t *transpiler) __loadScript(bundle Bundle, filename string, source string)
 	return t.sandbox.Execute(script)
 }
 
-func (t *transpiler) __findTranspiledModule(filename string) *transpiledModule {
+func (t *transpiler) __findTranspiledModule(cacheFile string) *transpiledModule {
 	for _, module := range t.transpilerCache.Modules {
-		if module.OriginalFile == filename {
+		if module.CacheFile == cacheFile {
 			return &module
 		}
 	}
@@ -113,7 +113,7 @@ func (t *transpiler) __removeTranspiledModule(module *transpiledModule) error {
 	}
 
 	for i, temp := range t.transpilerCache.Modules {
-		if temp.OriginalFile == module.OriginalFile {
+		if temp.CacheFile == module.CacheFile {
 			t.transpilerCache.Modules = append(t.transpilerCache.Modules[:i], t.transpilerCache.Modules[i+1:]...)
 			return nil
 		}
@@ -153,14 +153,14 @@ func (t *transpiler) __storeModuleCacheInformation() error {
 const tscSource = `
 tsVersion(ts.version);
 
-function transpiler(source) {
+function transpiler(source, target) {
     var result = ts.transpileModule(source, {
         compilerOptions: {
             moduleResolution: "node",
             module: "System",
-            target: "es5",
+            target: target,
             isolatedModules: true,
-            importHelpers: true,
+            noEmitHelpers: true,
             tsconfig: false,
             noImplicitAny: false,
             alwaysStrict: true,
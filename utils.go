@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"github.com/spf13/afero"
+	"path/filepath"
 )
 
 const bannerLarge = `       __           __  _                                _      _       
@@ -55,7 +56,62 @@ func loadPlainJavascript(kernel *kernel, filename string, loader, target Bundle)
 	}
 }
 
+// realPath resolves the path to the path inside of the host filesystem,
+// if the given filesystem supports it
+func realPath(filesystem afero.Fs, path string) (string, error) {
+	if resolver, ok := filesystem.(interface {
+		RealPath(name string) (string, error)
+	}); ok {
+		return resolver.RealPath(path)
+	}
+	return path, nil
+}
+
+// copyPath copies the file or directory tree at source to target
+func copyPath(filesystem afero.Fs, source, target string) error {
+	return afero.Walk(filesystem, source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		destination := filepath.Join(target, relative)
+
+		if info.IsDir() {
+			return filesystem.MkdirAll(destination, info.Mode())
+		}
+
+		data, err := afero.ReadFile(filesystem, path)
+		if err != nil {
+			return err
+		}
+		return afero.WriteFile(filesystem, destination, data, info.Mode())
+	})
+}
+
+// writeSynced writes the file and flushes it to the storage before it is
+// closed, e.g. before the file is renamed to replace another one
+func writeSynced(filesystem afero.Fs, filename string, data []byte) error {
+	file, err := filesystem.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func tsCacheFilename(path string, bundle Bundle, kernel *kernel) string {
+	// Transpiled scripts differ depending on the transpiler target
 	kernelBasedPath := kernel.toKernelPath(path, bundle)
-	return hash(kernelBasedPath)
+	return hash(kernel.transpilerTarget(bundle) + ":" + kernelBasedPath)
 }
@@ -0,0 +1,318 @@
+package gomini
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"github.com/go-errors/errors"
+)
+
+// Version is a semantic version as defined by https://semver.org.
+// The build metadata is kept but ignored when comparing versions.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string
+	Build      string
+}
+
+// ParseVersion parses a semantic version like "1.4.2", "1.0.0-beta.1"
+// or "2.1.0+20180720". A leading "v" is accepted.
+func ParseVersion(value string) (Version, error) {
+	version, parts, err := parsePartialVersion(value)
+	if err != nil {
+		return Version{}, err
+	}
+	if parts != 3 {
+		return Version{}, errors.New(fmt.Sprintf("illegal version, major.minor.patch expected: %s", value))
+	}
+	return version, nil
+}
+
+// Compare returns -1, 0 or +1 depending on this version being older
+// than, equal to or newer than the given version.
+func (v Version) Compare(other Version) int {
+	if c := compareNumbers(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareNumbers(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareNumbers(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	// A version without pre-release has a higher precedence
+	switch {
+	case len(v.PreRelease) == 0 && len(other.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(other.PreRelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.PreRelease) && i < len(other.PreRelease); i++ {
+		if c := comparePreRelease(v.PreRelease[i], other.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareNumbers(uint64(len(v.PreRelease)), uint64(len(other.PreRelease)))
+}
+
+func (v Version) NewerThan(other Version) bool {
+	return v.Compare(other) > 0
+}
+
+func (v Version) OlderThan(other Version) bool {
+	return v.Compare(other) < 0
+}
+
+func (v Version) Equal(other Version) bool {
+	return v.Compare(other) == 0
+}
+
+func (v Version) String() string {
+	version := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		version += "-" + strings.Join(v.PreRelease, ".")
+	}
+	if v.Build != "" {
+		version += "+" + v.Build
+	}
+	return version
+}
+
+// describeVersionChange describes the candidate version relative to the
+// installed one, e.g. "1.2.0 (upgrade)".
+func describeVersionChange(installed, candidate Version) string {
+	switch installed.Compare(candidate) {
+	case -1:
+		return candidate.String() + " (upgrade)"
+	case 1:
+		return candidate.String() + " (downgrade)"
+	}
+	return candidate.String() + " (same version)"
+}
+
+func compareNumbers(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func comparePreRelease(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+
+	// Numeric identifiers always have lower precedence than alphanumeric ones
+	switch {
+	case errA == nil && errB == nil:
+		return compareNumbers(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// parsePartialVersion parses a possibly incomplete version as used in
+// version ranges ("1", "1.2", "1.2.x", "*") and returns the number of
+// given version components.
+func parsePartialVersion(value string) (Version, int, error) {
+	version := Version{}
+
+	value = strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if index := strings.Index(value, "+"); index != -1 {
+		version.Build = value[index+1:]
+		value = value[:index]
+	}
+	if index := strings.Index(value, "-"); index != -1 {
+		preRelease := value[index+1:]
+		if preRelease == "" {
+			return Version{}, 0, errors.New(fmt.Sprintf("illegal empty pre-release: %s", value))
+		}
+		version.PreRelease = strings.Split(preRelease, ".")
+		value = value[:index]
+	}
+
+	if value == "" || value == "*" || value == "x" || value == "X" {
+		return version, 0, nil
+	}
+
+	segments := strings.Split(value, ".")
+	if len(segments) > 3 {
+		return Version{}, 0, errors.New(fmt.Sprintf("illegal version: %s", value))
+	}
+
+	numbers := []*uint64{&version.Major, &version.Minor, &version.Patch}
+	parts := 0
+	for i, segment := range segments {
+		if segment == "*" || segment == "x" || segment == "X" {
+			break
+		}
+		number, err := strconv.ParseUint(segment, 10, 64)
+		if err != nil {
+			return Version{}, 0, errors.New(fmt.Sprintf("illegal version: %s", value))
+		}
+		*numbers[i] = number
+		parts++
+	}
+
+	if parts < 3 && len(version.PreRelease) > 0 {
+		return Version{}, 0, errors.New(fmt.Sprintf("illegal pre-release on partial version: %s", value))
+	}
+	return version, parts, nil
+}
+
+type versionComparator struct {
+	operator string
+	version  Version
+}
+
+func (c versionComparator) matches(version Version) bool {
+	compare := version.Compare(c.version)
+	switch c.operator {
+	case ">":
+		return compare > 0
+	case ">=":
+		return compare >= 0
+	case "<":
+		return compare < 0
+	case "<=":
+		return compare <= 0
+	}
+	return compare == 0
+}
+
+// versionRange is a set of alternatives ("||"), each being a set of
+// comparators which all have to match, e.g. ">=1.2.0 <2.0.0 || ^3.1".
+// Besides the plain comparison operators, "~" (patch updates), "^"
+// (compatible updates) and partial or wildcard versions are supported.
+type versionRange struct {
+	alternatives [][]versionComparator
+}
+
+func parseVersionRange(value string) (*versionRange, error) {
+	versionRange := &versionRange{}
+	for _, alternative := range strings.Split(value, "||") {
+		comparators := make([]versionComparator, 0)
+		for _, field := range strings.Fields(alternative) {
+			expanded, err := parseVersionComparator(field)
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, expanded...)
+		}
+		versionRange.alternatives = append(versionRange.alternatives, comparators)
+	}
+	return versionRange, nil
+}
+
+func parseVersionComparator(value string) ([]versionComparator, error) {
+	operator := ""
+	for _, candidate := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(value, candidate) {
+			operator = candidate
+			break
+		}
+	}
+
+	version, parts, err := parsePartialVersion(value[len(operator):])
+	if err != nil {
+		return nil, err
+	}
+
+	nextMajor := Version{Major: version.Major + 1}
+	nextMinor := Version{Major: version.Major, Minor: version.Minor + 1}
+	nextPatch := Version{Major: version.Major, Minor: version.Minor, Patch: version.Patch + 1}
+
+	// Upper bound of a partial version, e.g. "1.2" => "<1.3.0"
+	upper := func() versionComparator {
+		if parts == 1 {
+			return versionComparator{"<", nextMajor}
+		}
+		return versionComparator{"<", nextMinor}
+	}
+
+	switch operator {
+	case "", "=":
+		switch parts {
+		case 0:
+			return []versionComparator{}, nil
+		case 3:
+			return []versionComparator{{"=", version}}, nil
+		}
+		return []versionComparator{{">=", version}, upper()}, nil
+
+	case ">":
+		switch parts {
+		case 0:
+			return nil, errors.New(fmt.Sprintf("illegal version comparator: %s", value))
+		case 3:
+			return []versionComparator{{">", version}}, nil
+		case 2:
+			return []versionComparator{{">=", nextMinor}}, nil
+		}
+		return []versionComparator{{">=", nextMajor}}, nil
+
+	case "<=":
+		switch parts {
+		case 0:
+			return []versionComparator{}, nil
+		case 3:
+			return []versionComparator{{"<=", version}}, nil
+		}
+		return []versionComparator{upper()}, nil
+
+	case ">=", "<":
+		if parts == 0 {
+			if operator == "<" {
+				return nil, errors.New(fmt.Sprintf("illegal version comparator: %s", value))
+			}
+			return []versionComparator{}, nil
+		}
+		return []versionComparator{{operator, version}}, nil
+
+	case "~":
+		if parts == 0 {
+			return []versionComparator{}, nil
+		}
+		return []versionComparator{{">=", version}, upper()}, nil
+
+	case "^":
+		switch {
+		case parts == 0:
+			return []versionComparator{}, nil
+		case version.Major > 0 || parts == 1:
+			return []versionComparator{{">=", version}, {"<", nextMajor}}, nil
+		case version.Minor > 0 || parts == 2:
+			return []versionComparator{{">=", version}, {"<", nextMinor}}, nil
+		}
+		return []versionComparator{{">=", version}, {"<", nextPatch}}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("illegal version comparator: %s", value))
+}
+
+func (r *versionRange) matches(version Version) bool {
+	for _, comparators := range r.alternatives {
+		matches := true
+		for _, comparator := range comparators {
+			if !comparator.matches(version) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package gomini
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	version, err := ParseVersion("v1.4.2-beta.1+20180720")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Major != 1 || version.Minor != 4 || version.Patch != 2 {
+		t.Fatalf("unexpected version %s", version)
+	}
+	if len(version.PreRelease) != 2 || version.PreRelease[0] != "beta" || version.PreRelease[1] != "1" {
+		t.Fatalf("unexpected pre-release %v", version.PreRelease)
+	}
+	if version.Build != "20180720" {
+		t.Fatalf("unexpected build %s", version.Build)
+	}
+
+	for _, illegal := range []string{"", "1", "1.2", "1.2.x", "1.2.3.4", "a.b.c", "1.2.3-"} {
+		if _, err := ParseVersion(illegal); err == nil {
+			t.Errorf("%q was accepted as version", illegal)
+		}
+	}
+}
+
+func TestVersionOrder(t *testing.T) {
+	ordered := []string{
+		"0.9.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0",
+	}
+	for i := 1; i < len(ordered); i++ {
+		older, newer := mustParseVersion(t, ordered[i-1]), mustParseVersion(t, ordered[i])
+		if !newer.NewerThan(older) || !older.OlderThan(newer) {
+			t.Errorf("%s is expected to be newer than %s", newer, older)
+		}
+	}
+
+	if !mustParseVersion(t, "1.0.0+a").Equal(mustParseVersion(t, "1.0.0+b")) {
+		t.Error("build metadata must be ignored when comparing versions")
+	}
+}
+
+func TestVersionRange(t *testing.T) {
+	tests := []struct {
+		versionRange string
+		matching     []string
+		other        []string
+	}{
+		{"", []string{"0.0.1", "3.2.1"}, nil},
+		{"*", []string{"0.0.1", "3.2.1"}, nil},
+		{"1.2.3", []string{"1.2.3"}, []string{"1.2.4", "1.2.2"}},
+		{"1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0", "1.1.9"}},
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"2.0.0", "0.9.9"}},
+		{">1.2.3", []string{"1.2.4", "2.0.0"}, []string{"1.2.3"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9", "0.1.0"}, []string{"1.3.0"}},
+		{">=1.2.0 <2.0.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "1.2.2"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"^1.2 || ^3.1", []string{"1.5.0", "3.1.0"}, []string{"2.0.0", "3.0.9"}},
+	}
+
+	for _, test := range tests {
+		versionRange, err := parseVersionRange(test.versionRange)
+		if err != nil {
+			t.Errorf("parsing %q failed: %s", test.versionRange, err)
+			continue
+		}
+		for _, version := range test.matching {
+			if !versionRange.matches(mustParseVersion(t, version)) {
+				t.Errorf("%q doesn't match %s", test.versionRange, version)
+			}
+		}
+		for _, version := range test.other {
+			if versionRange.matches(mustParseVersion(t, version)) {
+				t.Errorf("%q matches %s", test.versionRange, version)
+			}
+		}
+	}
+
+	for _, illegal := range []string{">", "<*", "1.a", "!1.2.3"} {
+		if _, err := parseVersionRange(illegal); err == nil {
+			t.Errorf("%q was accepted as version range", illegal)
+		}
+	}
+}
+
+func mustParseVersion(t *testing.T, value string) Version {
+	version, err := ParseVersion(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}